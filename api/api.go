@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/codelist"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/query"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const consentPath = "/consent"
+
+// Origin is recorded on the commands dispatched by the API, e.g. as the origin of a cancellation
+const Origin = "api"
+
+// API exposes the consent commands and read models over HTTP
+type API struct {
+	CommandHandler eh.CommandHandler
+	// Consents answers the queries on the consent.Consent read models
+	Consents *query.Service
+	// Codes contains the classes and purposes of use a consent may have
+	Codes codelist.List
+}
+
+// ProposeRequest is the JSON body for proposing a new consent.
+// Class is the category of data the consent covers and PurposeOfUse the HL7 PurposeOfUse code, both must be in the code list.
+type ProposeRequest struct {
+	CustodianID  string     `json:"custodianID"`
+	SubjectID    string     `json:"subjectID"`
+	ActorID      string     `json:"actorID"`
+	Class        string     `json:"class"`
+	PurposeOfUse string     `json:"purposeOfUse"`
+	Start        time.Time  `json:"start"`
+	End          *time.Time `json:"end,omitempty"`
+}
+
+// CancelRequest is the JSON body for cancelling a consent
+type CancelRequest struct {
+	Reason string `json:"reason"`
+}
+
+// WithdrawRequest is the JSON body for withdrawing a completed consent
+type WithdrawRequest struct {
+	WithdrawnBy string `json:"withdrawnBy"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// AmendRequest is the JSON body for amending a completed consent, the terms which are left out are not changed
+type AmendRequest struct {
+	End          *time.Time `json:"end,omitempty"`
+	Class        string     `json:"class,omitempty"`
+	PurposeOfUse string     `json:"purposeOfUse,omitempty"`
+}
+
+// IDResponse is returned when a command has been accepted
+type IDResponse struct {
+	ID uuid.UUID `json:"id"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// ServeHTTP routes:
+//
+//	POST /consent               propose a consent
+//	GET  /consent               list the consents, filtered by the subject, custodian, actor, status and validAt parameters
+//	GET  /consent/{id}          get a consent
+//	POST /consent/{id}/cancel   cancel a consent which is not completed yet, also while it is negotiated
+//	POST /consent/{id}/withdraw withdraw a completed consent
+//	POST /consent/{id}/amend    amend the terms of a completed consent
+func (a API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, consentPath) {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, consentPath), "/")
+	segments := strings.Split(path, "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodPost:
+		a.propose(w, r)
+	case path == "" && r.Method == http.MethodGet:
+		a.list(w, r)
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		a.get(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "cancel" && r.Method == http.MethodPost:
+		a.cancel(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "withdraw" && r.Method == http.MethodPost:
+		a.withdraw(w, r, segments[0])
+	case len(segments) == 2 && segments[1] == "amend" && r.Method == http.MethodPost:
+		a.amend(w, r, segments[0])
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (a API) propose(w http.ResponseWriter, r *http.Request) {
+	req := ProposeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("could not parse request: %w", err))
+		return
+	}
+	if err := req.validate(a.Codes); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cmd := &consent.Propose{
+		ID:           uuid.New(),
+		CustodianID:  req.CustodianID,
+		SubjectID:    req.SubjectID,
+		ActorID:      req.ActorID,
+		Class:        req.Class,
+		PurposeOfUse: req.PurposeOfUse,
+		Start:        req.Start,
+	}
+	if req.End != nil {
+		cmd.End = *req.End
+	}
+	a.handleCommand(w, r, cmd)
+}
+
+func (a API) cancel(w http.ResponseWriter, r *http.Request, rawID string) {
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid consent id: %w", err))
+		return
+	}
+	req := CancelRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("could not parse request: %w", err))
+		return
+	}
+	if req.Reason == "" {
+		writeError(w, http.StatusBadRequest, errors.New("reason is required"))
+		return
+	}
+	a.handleCommand(w, r, &consent.Cancel{ID: id, Reason: req.Reason, Code: domain.ErrorCodeCanceledByClient, Origin: Origin})
+}
+
+func (a API) withdraw(w http.ResponseWriter, r *http.Request, rawID string) {
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid consent id: %w", err))
+		return
+	}
+	req := WithdrawRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("could not parse request: %w", err))
+		return
+	}
+	if strings.TrimSpace(req.WithdrawnBy) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("withdrawnBy is required"))
+		return
+	}
+	a.handleCommand(w, r, &consent.Withdraw{ID: id, WithdrawnBy: req.WithdrawnBy, Reason: req.Reason})
+}
+
+func (a API) amend(w http.ResponseWriter, r *http.Request, rawID string) {
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid consent id: %w", err))
+		return
+	}
+	req := AmendRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("could not parse request: %w", err))
+		return
+	}
+	if req.End == nil && req.Class == "" && req.PurposeOfUse == "" {
+		writeError(w, http.StatusBadRequest, errors.New("nothing to amend"))
+		return
+	}
+	if err := validateCodes(a.Codes, req.Class, req.PurposeOfUse); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	a.handleCommand(w, r, &consent.Amend{ID: id, End: req.End, Class: req.Class, PurposeOfUse: req.PurposeOfUse})
+}
+
+func (a API) get(w http.ResponseWriter, r *http.Request, rawID string) {
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid consent id: %w", err))
+		return
+	}
+	model, err := a.Consents.Get(r.Context(), id)
+	if err != nil {
+		writeError(w, statusForError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, model)
+}
+
+func (a API) list(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	filter := query.Filter{
+		SubjectID:   params.Get("subject"),
+		CustodianID: params.Get("custodian"),
+		ActorID:     params.Get("actor"),
+		Status:      consent.ConsentAggregateState(params.Get("status")),
+	}
+	if validAt := params.Get("validAt"); validAt != "" {
+		at, err := time.Parse(time.RFC3339, validAt)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid validAt: %w", err))
+			return
+		}
+		filter.ValidAt = at
+	}
+	models, err := a.Consents.Find(r.Context(), filter)
+	if err != nil {
+		writeError(w, statusForError(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, models)
+}
+
+func (a API) handleCommand(w http.ResponseWriter, r *http.Request, cmd eh.Command) {
+	// The resulting events are handled asynchronously, after the response has been sent.
+	// The request context is canceled by then, so it must not be passed on.
+	if err := a.CommandHandler.HandleCommand(context.Background(), cmd); err != nil {
+		log.Printf("[API] command %s failed: %v\n", cmd.CommandType(), err)
+		status := statusForError(err)
+		if status == http.StatusNotFound {
+			err = fmt.Errorf("consent %s not found", cmd.AggregateID())
+		}
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, IDResponse{ID: cmd.AggregateID()})
+}
+
+func (req ProposeRequest) validate(codes codelist.List) error {
+	var missing []string
+	if strings.TrimSpace(req.CustodianID) == "" {
+		missing = append(missing, "custodianID")
+	}
+	if strings.TrimSpace(req.SubjectID) == "" {
+		missing = append(missing, "subjectID")
+	}
+	if strings.TrimSpace(req.ActorID) == "" {
+		missing = append(missing, "actorID")
+	}
+	if strings.TrimSpace(req.Class) == "" {
+		missing = append(missing, "class")
+	}
+	if strings.TrimSpace(req.PurposeOfUse) == "" {
+		missing = append(missing, "purposeOfUse")
+	}
+	if req.Start.IsZero() {
+		missing = append(missing, "start")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required fields: %s", strings.Join(missing, ", "))
+	}
+	if req.End != nil && !req.End.After(req.Start) {
+		return errors.New("end must be after start")
+	}
+	return validateCodes(codes, req.Class, req.PurposeOfUse)
+}
+
+// validateCodes checks the class and purpose of use against the code list, the CheckPartiesSaga checks them again
+// when the consent is proposed or amended. An empty code is not checked, an amendment leaves it unchanged.
+func validateCodes(codes codelist.List, class, purposeOfUse string) error {
+	if class != "" {
+		if err := codes.ValidateClass(class); err != nil {
+			return err
+		}
+	}
+	if purposeOfUse != "" {
+		if err := codes.ValidatePurposeOfUse(purposeOfUse); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusForError maps domain and eventhorizon errors to HTTP status codes.
+// A command for a consent which was never proposed is rejected in the new state, it does not exist.
+func statusForError(err error) int {
+	var fieldErr eh.CommandFieldError
+	var repoErr eh.RepoError
+	var transitionErr domain.InvalidTransitionError
+	switch {
+	case errors.As(err, &fieldErr), errors.Is(err, domain.ErrInvalidAmendment):
+		return http.StatusBadRequest
+	case errors.As(err, &transitionErr) && transitionErr.State == string(consent.ConsentRequestNew):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrInvalidTransition), errors.Is(err, domain.ErrAggregateCancelled):
+		return http.StatusConflict
+	case errors.As(err, &repoErr) && repoErr.Err == eh.ErrEntityNotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("[API] could not write response: %v\n", err)
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/nuts-foundation/nuts-consent-service/codelist"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/query"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type commandRecorder struct {
+	commands []eh.Command
+	err      error
+}
+
+func (c *commandRecorder) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	if err := eh.CheckCommand(cmd); err != nil {
+		return err
+	}
+	c.commands = append(c.commands, cmd)
+	return c.err
+}
+
+func TestAPI_ServeHTTP(t *testing.T) {
+	existingID := uuid.New()
+	repo := query.NewRepo(memory.NewRepo())
+	if err := repo.Save(context.Background(), &consent.Consent{ID: existingID, Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		method         string
+		path           string
+		body           string
+		commandErr     error
+		expectedStatus int
+		expectedCmd    eh.CommandType
+	}{
+		"propose": {
+			http.MethodPost, "/consent",
+			`{"custodianID":"agb:123","subjectID":"bsn:999","actorID":"agb:456","class":"medication","purposeOfUse":"TREAT","start":"2020-01-01T00:00:00Z"}`,
+			nil, http.StatusAccepted, consent.ProposeCmdType,
+		},
+		"propose with missing fields": {
+			http.MethodPost, "/consent",
+			`{"custodianID":"agb:123"}`,
+			nil, http.StatusBadRequest, "",
+		},
+		"propose without class and purpose of use": {
+			http.MethodPost, "/consent",
+			`{"custodianID":"agb:123","subjectID":"bsn:999","actorID":"agb:456","start":"2020-01-01T00:00:00Z"}`,
+			nil, http.StatusBadRequest, "",
+		},
+		"propose with unknown class": {
+			http.MethodPost, "/consent",
+			`{"custodianID":"agb:123","subjectID":"bsn:999","actorID":"agb:456","class":"transfer","purposeOfUse":"TREAT","start":"2020-01-01T00:00:00Z"}`,
+			nil, http.StatusBadRequest, "",
+		},
+		"propose with unknown purpose of use": {
+			http.MethodPost, "/consent",
+			`{"custodianID":"agb:123","subjectID":"bsn:999","actorID":"agb:456","class":"medication","purposeOfUse":"treatment","start":"2020-01-01T00:00:00Z"}`,
+			nil, http.StatusBadRequest, "",
+		},
+		"propose with end before start": {
+			http.MethodPost, "/consent",
+			`{"custodianID":"agb:123","subjectID":"bsn:999","actorID":"agb:456","class":"medication","purposeOfUse":"TREAT","start":"2020-01-01T00:00:00Z","end":"2019-01-01T00:00:00Z"}`,
+			nil, http.StatusBadRequest, "",
+		},
+		"propose with invalid json": {
+			http.MethodPost, "/consent", `{`,
+			nil, http.StatusBadRequest, "",
+		},
+		"cancel": {
+			http.MethodPost, "/consent/" + existingID.String() + "/cancel", `{"reason":"patient request"}`,
+			nil, http.StatusAccepted, consent.CancelCmdType,
+		},
+		"cancel without reason": {
+			http.MethodPost, "/consent/" + existingID.String() + "/cancel", `{}`,
+			nil, http.StatusBadRequest, "",
+		},
+		"cancel with invalid id": {
+			http.MethodPost, "/consent/foo/cancel", `{"reason":"patient request"}`,
+			nil, http.StatusBadRequest, "",
+		},
+		"cancel in wrong state": {
+			http.MethodPost, "/consent/" + existingID.String() + "/cancel", `{"reason":"patient request"}`,
+			domain.InvalidTransitionError{State: "completed", Command: string(consent.CancelCmdType)}, http.StatusConflict, consent.CancelCmdType,
+		},
+		"cancel unknown": {
+			http.MethodPost, "/consent/" + uuid.New().String() + "/cancel", `{"reason":"patient request"}`,
+			domain.InvalidTransitionError{State: string(consent.ConsentRequestNew), Command: string(consent.CancelCmdType)}, http.StatusNotFound, consent.CancelCmdType,
+		},
+		"cancel when cancelled": {
+			http.MethodPost, "/consent/" + existingID.String() + "/cancel", `{"reason":"patient request"}`,
+			domain.ErrAggregateCancelled, http.StatusConflict, consent.CancelCmdType,
+		},
+		"withdraw": {
+			http.MethodPost, "/consent/" + existingID.String() + "/withdraw", `{"withdrawnBy":"bsn:999999990","reason":"changed my mind"}`,
+			nil, http.StatusAccepted, consent.WithdrawCmdType,
+		},
+		"withdraw without withdrawnBy": {
+			http.MethodPost, "/consent/" + existingID.String() + "/withdraw", `{"reason":"changed my mind"}`,
+			nil, http.StatusBadRequest, "",
+		},
+		"withdraw when not completed": {
+			http.MethodPost, "/consent/" + existingID.String() + "/withdraw", `{"withdrawnBy":"bsn:999999990"}`,
+			domain.InvalidTransitionError{State: "syncing", Command: string(consent.WithdrawCmdType)}, http.StatusConflict, consent.WithdrawCmdType,
+		},
+		"withdraw unknown": {
+			http.MethodPost, "/consent/" + uuid.New().String() + "/withdraw", `{"withdrawnBy":"bsn:999999990"}`,
+			domain.InvalidTransitionError{State: string(consent.ConsentRequestNew), Command: string(consent.WithdrawCmdType)}, http.StatusNotFound, consent.WithdrawCmdType,
+		},
+		"amend": {
+			http.MethodPost, "/consent/" + existingID.String() + "/amend", `{"end":"2022-01-01T00:00:00Z"}`,
+			nil, http.StatusAccepted, consent.AmendCmdType,
+		},
+		"amend purpose of use": {
+			http.MethodPost, "/consent/" + existingID.String() + "/amend", `{"purposeOfUse":"HRESCH"}`,
+			nil, http.StatusAccepted, consent.AmendCmdType,
+		},
+		"amend with unknown class": {
+			http.MethodPost, "/consent/" + existingID.String() + "/amend", `{"class":"transfer"}`,
+			nil, http.StatusBadRequest, "",
+		},
+		"amend with unknown purpose of use": {
+			http.MethodPost, "/consent/" + existingID.String() + "/amend", `{"end":"2022-01-01T00:00:00Z","purposeOfUse":"treatment"}`,
+			nil, http.StatusBadRequest, "",
+		},
+		"amend without changes": {
+			http.MethodPost, "/consent/" + existingID.String() + "/amend", `{}`,
+			nil, http.StatusBadRequest, "",
+		},
+		"amend with invalid terms": {
+			http.MethodPost, "/consent/" + existingID.String() + "/amend", `{"end":"2019-01-01T00:00:00Z"}`,
+			fmt.Errorf("%w: end must be after start", domain.ErrInvalidAmendment), http.StatusBadRequest, consent.AmendCmdType,
+		},
+		"amend when not completed": {
+			http.MethodPost, "/consent/" + existingID.String() + "/amend", `{"end":"2022-01-01T00:00:00Z"}`,
+			domain.InvalidTransitionError{State: "syncing", Command: string(consent.AmendCmdType)}, http.StatusConflict, consent.AmendCmdType,
+		},
+		"amend unknown": {
+			http.MethodPost, "/consent/" + uuid.New().String() + "/amend", `{"end":"2022-01-01T00:00:00Z"}`,
+			domain.InvalidTransitionError{State: string(consent.ConsentRequestNew), Command: string(consent.AmendCmdType)}, http.StatusNotFound, consent.AmendCmdType,
+		},
+		"get": {
+			http.MethodGet, "/consent/" + existingID.String(), "",
+			nil, http.StatusOK, "",
+		},
+		"get unknown": {
+			http.MethodGet, "/consent/" + uuid.New().String(), "",
+			nil, http.StatusNotFound, "",
+		},
+		"list": {
+			http.MethodGet, "/consent", "",
+			nil, http.StatusOK, "",
+		},
+		"list filtered": {
+			http.MethodGet, "/consent?subject=bsn:999&status=completed&validAt=2020-01-01T00:00:00Z", "",
+			nil, http.StatusOK, "",
+		},
+		"list with invalid validAt": {
+			http.MethodGet, "/consent?validAt=yesterday", "",
+			nil, http.StatusBadRequest, "",
+		},
+		"unknown route": {
+			http.MethodDelete, "/consent/" + existingID.String(), "",
+			nil, http.StatusNotFound, "",
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			recorder := &commandRecorder{err: testcase.commandErr}
+			api := API{CommandHandler: recorder, Consents: query.NewService(repo), Codes: codelist.Default()}
+
+			req := httptest.NewRequest(testcase.method, testcase.path, strings.NewReader(testcase.body))
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+
+			if rec.Code != testcase.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", testcase.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if testcase.expectedCmd == "" {
+				if len(recorder.commands) != 0 {
+					t.Errorf("expected no commands, got %v", recorder.commands)
+				}
+				return
+			}
+			if len(recorder.commands) != 1 || recorder.commands[0].CommandType() != testcase.expectedCmd {
+				t.Errorf("expected a single %s command, got %v", testcase.expectedCmd, recorder.commands)
+			}
+		})
+	}
+}
+
+func TestAPI_ProposeReturnsAggregateID(t *testing.T) {
+	recorder := &commandRecorder{}
+	api := API{CommandHandler: recorder, Consents: query.NewService(query.NewRepo(memory.NewRepo())), Codes: codelist.Default()}
+
+	body := `{"custodianID":"agb:123","subjectID":"bsn:999","actorID":"agb:456","class":"medication","purposeOfUse":"TREAT","start":"2020-01-01T00:00:00Z"}`
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/consent", strings.NewReader(body)))
+
+	response := IDResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.commands) != 1 || response.ID != recorder.commands[0].AggregateID() {
+		t.Errorf("expected the id of the proposed aggregate, got %s", response.ID)
+	}
+}
+
+func TestAPI_CommandOutlivesRequest(t *testing.T) {
+	var ctxErr error
+	api := API{CommandHandler: eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+		ctxErr = ctx.Err()
+		return nil
+	}), Consents: query.NewService(query.NewRepo(memory.NewRepo()))}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodPost, "/consent/"+uuid.New().String()+"/cancel", strings.NewReader(`{"reason":"duplicate"}`)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted || ctxErr != nil {
+		t.Errorf("expected the command to be handled outside the request context, got status %d and context error %v", rec.Code, ctxErr)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/nuts-foundation/nuts-consent-service/projection"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const projectionsPath = "/projections"
+
+// Projections exposes the rebuild of the projections over HTTP.
+// A rebuild must be authorized with Token as bearer token, rebuilding is disabled when Token is not set.
+type Projections struct {
+	// Load loads the stored events to rebuild from
+	Load        projection.LoadFunc
+	Projections []*projection.Projection
+	Token       string
+}
+
+// RebuildResponse is returned when a projection has been rebuilt
+type RebuildResponse struct {
+	Projection string `json:"projection"`
+	Events     int    `json:"events"`
+}
+
+// ServeHTTP routes:
+//
+//	POST /projections/{type}/rebuild rebuild the projection from the stored events, requires the token
+func (p Projections) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, projectionsPath), "/"), "/")
+	if len(segments) != 2 || segments[1] != "rebuild" || r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if p.Token == "" {
+		writeError(w, http.StatusForbidden, errors.New("rebuilding projections is disabled"))
+		return
+	}
+	if !p.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+		return
+	}
+
+	for _, target := range p.Projections {
+		if string(target.Type()) != segments[0] {
+			continue
+		}
+		logProgress := projection.LogProgress(target.Type())
+		events := 0
+		err := target.Rebuild(r.Context(), p.Load, func(handled, total int) {
+			events = total
+			logProgress(handled, total)
+		})
+		if err != nil {
+			log.Printf("[API] rebuilding %s failed: %v\n", target.Type(), err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, RebuildResponse{Projection: string(target.Type()), Events: events})
+		return
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("unknown projection: %s", segments[0]))
+}
+
+// authorized returns true when the request carries Token as bearer token
+func (p Projections) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(p.Token)) == 1
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"errors"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/projection"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProjections_ServeHTTP(t *testing.T) {
+	consentProjection := projection.New(&consent.ConsentProjector{}, eh.MatchAny(),
+		func() eh.Entity { return &consent.Consent{} },
+		func() eh.ReadWriteRepo { return memory.NewRepo() })
+
+	cases := map[string]struct {
+		method         string
+		path           string
+		token          string
+		authorization  string
+		loadErr        error
+		expectedStatus int
+	}{
+		"rebuild": {
+			http.MethodPost, "/projections/consent-projector/rebuild", "secret", "Bearer secret", nil, http.StatusOK,
+		},
+		"rebuild fails": {
+			http.MethodPost, "/projections/consent-projector/rebuild", "secret", "Bearer secret", errors.New("disk on fire"), http.StatusInternalServerError,
+		},
+		"rebuild without token": {
+			http.MethodPost, "/projections/consent-projector/rebuild", "secret", "", nil, http.StatusUnauthorized,
+		},
+		"rebuild with wrong token": {
+			http.MethodPost, "/projections/consent-projector/rebuild", "secret", "Bearer guess", nil, http.StatusUnauthorized,
+		},
+		"rebuild disabled": {
+			http.MethodPost, "/projections/consent-projector/rebuild", "", "", nil, http.StatusForbidden,
+		},
+		"unknown projection": {
+			http.MethodPost, "/projections/foo/rebuild", "secret", "Bearer secret", nil, http.StatusNotFound,
+		},
+		"unknown route": {
+			http.MethodGet, "/projections/consent-projector/rebuild", "secret", "Bearer secret", nil, http.StatusNotFound,
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			api := Projections{
+				Load: func(ctx context.Context) ([]eh.Event, error) {
+					return nil, testcase.loadErr
+				},
+				Projections: []*projection.Projection{consentProjection},
+				Token:       testcase.token,
+			}
+
+			req := httptest.NewRequest(testcase.method, testcase.path, nil)
+			if testcase.authorization != "" {
+				req.Header.Set("Authorization", testcase.authorization)
+			}
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+
+			if rec.Code != testcase.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", testcase.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
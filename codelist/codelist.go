@@ -0,0 +1,90 @@
+package codelist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// ErrUnknownClass is returned when a consent class is not in the code list
+var ErrUnknownClass = errors.New("unknown consent class")
+
+// ErrUnknownPurposeOfUse is returned when a purpose of use is not in the code list
+var ErrUnknownPurposeOfUse = errors.New("unknown purpose of use")
+
+// Code is a single entry of a code list
+type Code struct {
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+// List contains the consent classes, which are the categories of data a consent covers,
+// and the purposes of use a consent may be given for, which are codes of the HL7 v3 PurposeOfUse value set
+type List struct {
+	Classes       []Code `json:"classes"`
+	PurposesOfUse []Code `json:"purposesOfUse"`
+}
+
+// Default returns the code list which is used when none is configured
+func Default() List {
+	return List{
+		Classes: []Code{
+			{Code: "medication", Display: "Medication"},
+			{Code: "lab-results", Display: "Laboratory results"},
+			{Code: "allergies", Display: "Allergies and intolerances"},
+			{Code: "problems", Display: "Problems and diagnoses"},
+			{Code: "vital-signs", Display: "Vital signs"},
+			{Code: "imaging", Display: "Imaging results"},
+		},
+		PurposesOfUse: []Code{
+			{Code: "TREAT", Display: "treatment"},
+			{Code: "ETREAT", Display: "emergency treatment"},
+			{Code: "CAREMGT", Display: "care management"},
+			{Code: "PATRQT", Display: "patient requested"},
+			{Code: "HRESCH", Display: "healthcare research"},
+			{Code: "PUBHLTH", Display: "public health"},
+		},
+	}
+}
+
+// Load reads a code list from a JSON file, both lists must contain at least one code
+func Load(path string) (List, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return List{}, err
+	}
+	var list List
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return List{}, err
+	}
+	if len(list.Classes) == 0 || len(list.PurposesOfUse) == 0 {
+		return List{}, fmt.Errorf("code list %s must contain classes and purposesOfUse", path)
+	}
+	return list, nil
+}
+
+// ValidateClass returns ErrUnknownClass when the class is not in the list
+func (l List) ValidateClass(class string) error {
+	if !contains(l.Classes, class) {
+		return fmt.Errorf("%w: '%s'", ErrUnknownClass, class)
+	}
+	return nil
+}
+
+// ValidatePurposeOfUse returns ErrUnknownPurposeOfUse when the purpose of use is not in the list
+func (l List) ValidatePurposeOfUse(purposeOfUse string) error {
+	if !contains(l.PurposesOfUse, purposeOfUse) {
+		return fmt.Errorf("%w: '%s'", ErrUnknownPurposeOfUse, purposeOfUse)
+	}
+	return nil
+}
+
+func contains(codes []Code, code string) bool {
+	for _, c := range codes {
+		if c.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package codelist
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestList_Validate(t *testing.T) {
+	list := Default()
+	cases := map[string]struct {
+		validate func(string) error
+		code     string
+		expected error
+	}{
+		"known class":          {list.ValidateClass, "medication", nil},
+		"unknown class":        {list.ValidateClass, "everything", ErrUnknownClass},
+		"empty class":          {list.ValidateClass, "", ErrUnknownClass},
+		"known purpose":        {list.ValidatePurposeOfUse, "TREAT", nil},
+		"purpose is exact":     {list.ValidatePurposeOfUse, "treat", ErrUnknownPurposeOfUse},
+		"class is not purpose": {list.ValidatePurposeOfUse, "medication", ErrUnknownPurposeOfUse},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			if err := testcase.validate(testcase.code); !errors.Is(err, testcase.expected) {
+				t.Errorf("expected %v, got %v", testcase.expected, err)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		file, err := ioutil.TempFile("", "codelist*.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(content)
+		file.Close()
+		return file.Name()
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		path := write(t, `{"classes":[{"code":"dental"}],"purposesOfUse":[{"code":"TREAT","display":"treatment"}]}`)
+		defer os.Remove(path)
+
+		list, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if list.ValidateClass("dental") != nil || list.ValidateClass("medication") == nil {
+			t.Errorf("expected only the configured classes to be valid, got %+v", list.Classes)
+		}
+	})
+
+	t.Run("without purposes", func(t *testing.T) {
+		path := write(t, `{"classes":[{"code":"dental"}]}`)
+		defer os.Remove(path)
+
+		if _, err := Load(path); err == nil {
+			t.Error("expected an error for a code list without purposes of use")
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := Load("does-not-exist.json"); err == nil {
+			t.Error("expected an error for a missing file")
+		}
+	})
+}
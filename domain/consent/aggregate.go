@@ -2,6 +2,7 @@ package consent
 
 import (
 	"context"
+	"fmt"
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/aggregatestore/events"
@@ -21,13 +22,44 @@ func init() {
 
 const ConsentAggregateType = eh.AggregateType("consent")
 
-type ConsentAggregateState string
+type ConsentAggregateState = domain.State
 
+// ConsentRequestNew is the state of an aggregate without any events
+const ConsentRequestNew = ConsentAggregateState("")
 const ConsentRequestPending = ConsentAggregateState("pending")
+const ConsentRequestUnique = ConsentAggregateState("unique")
+const ConsentRequestCustodianChecked = ConsentAggregateState("custodian-checked")
+
+// ConsentRequestChecked is the state in which both the uniqueness and custodian checks have passed
+const ConsentRequestChecked = ConsentAggregateState("checked")
+const ConsentRequestSyncing = ConsentAggregateState("syncing")
 const ConsentRequestCompleted = ConsentAggregateState("completed")
 const ConsentRequestErrored = ConsentAggregateState("errored")
 const ConsentRequestCanceled = ConsentAggregateState("canceled")
 
+// ConsentRequestExpired is the state of a completed consent of which the validity period has ended
+const ConsentRequestExpired = ConsentAggregateState("expired")
+
+// ConsentRequestWithdrawn is the state of a completed consent which has been withdrawn
+const ConsentRequestWithdrawn = ConsentAggregateState("withdrawn")
+
+// allowedTransitions lists per command the states in which the aggregate accepts it.
+// The resulting state is set by ApplyEvent.
+// The uniqueness and custodian checks run in parallel, so they are accepted in either order.
+// A consent can be canceled until it is completed, also while it is syncing.
+var allowedTransitions = domain.Transitions{
+	ProposeCmdType:              {ConsentRequestNew},
+	MarkAsUniqueCmdType:         {ConsentRequestPending, ConsentRequestCustodianChecked},
+	MarkCustodianCheckedCmdType: {ConsentRequestPending, ConsentRequestUnique},
+	StartSyncCmdType:            {ConsentRequestChecked},
+	MarkAsCompletedCmdType:      {ConsentRequestSyncing},
+	ExpireCmdType:               {ConsentRequestCompleted},
+	WithdrawCmdType:             {ConsentRequestCompleted},
+	AmendCmdType:                {ConsentRequestCompleted},
+	CancelCmdType:               {ConsentRequestPending, ConsentRequestUnique, ConsentRequestCustodianChecked, ConsentRequestChecked, ConsentRequestSyncing},
+	MarkAsErroredCmdType:        {ConsentRequestPending, ConsentRequestUnique, ConsentRequestCustodianChecked, ConsentRequestChecked, ConsentRequestSyncing},
+}
+
 var TimeNow = func() time.Time {
 	return time.Now()
 }
@@ -36,6 +68,11 @@ type ConsentAggregate struct {
 	*events.AggregateBase
 
 	State ConsentAggregateState
+	// Terms are the terms of the latest version of the consent and TermsVersion is its number
+	Terms        events2.ProposedData
+	TermsVersion int
+	// Previous are the terms in force while an amendment is in progress, nil otherwise
+	Previous *events2.ProposedData
 }
 
 func (c *ConsentAggregate) HandleCommand(ctx context.Context, command eh.Command) error {
@@ -46,33 +83,160 @@ func (c *ConsentAggregate) HandleCommand(ctx context.Context, command eh.Command
 		return domain.ErrAggregateCancelled
 	}
 
+	// While an amendment is in progress the previous version is still in force, so it can be withdrawn.
+	// The amendment is rejected first, the withdrawal ends the previous version.
+	if cmd, ok := command.(*Withdraw); ok && c.Previous != nil {
+		c.StoreEvent(events2.AmendmentRejected, events2.AmendmentRejectedData{
+			Reason: "consent withdrawn during the amendment",
+			Code:   domain.ErrorCodeWithdrawn,
+			Origin: string(WithdrawCmdType),
+		}, TimeNow())
+		c.StoreEvent(events2.Withdrawn, events2.WithdrawnData{WithdrawnBy: cmd.WithdrawnBy, Reason: cmd.Reason}, TimeNow())
+		return nil
+	}
+
+	if err := allowedTransitions.Check(c.State, command.CommandType()); err != nil {
+		return err
+	}
+	if err := checkErrorCode(command); err != nil {
+		return err
+	}
+
+	// When an amendment fails, the consent returns to the previous version instead of ending
+	if c.Previous != nil {
+		switch cmd := command.(type) {
+		case *MarkAsErrored:
+			c.StoreEvent(events2.AmendmentRejected, events2.AmendmentRejectedData{Reason: cmd.Reason, Code: cmd.Code, Origin: cmd.Origin}, TimeNow())
+			return nil
+		case *Cancel:
+			c.StoreEvent(events2.AmendmentRejected, events2.AmendmentRejectedData{Reason: cmd.Reason, Code: cmd.Code, Origin: cmd.Origin}, TimeNow())
+			return nil
+		}
+	}
+
 	switch cmd := command.(type) {
 	case *MarkAsErrored:
 		log.Printf("consent marked as errord with reason %s\n", cmd.Reason)
-		c.StoreEvent(events2.Errored, nil, TimeNow())
+		c.StoreEvent(events2.Errored, events2.ErroredData{Reason: cmd.Reason, Code: cmd.Code, Origin: cmd.Origin}, TimeNow())
 	case *Propose:
 		c.StoreEvent(events2.Proposed, events2.ProposedData{
-			ID:          cmd.ID,
-			CustodianID: cmd.CustodianID,
-			SubjectID:   cmd.SubjectID,
-			ActorID:     cmd.ActorID,
-			Start:       cmd.Start,
+			ID:           cmd.ID,
+			CustodianID:  cmd.CustodianID,
+			SubjectID:    cmd.SubjectID,
+			ActorID:      cmd.ActorID,
+			Class:        cmd.Class,
+			PurposeOfUse: cmd.PurposeOfUse,
+			Start:        cmd.Start,
+			End:          cmd.End,
 		}, TimeNow())
 	case *Cancel:
-		c.StoreEvent(events2.Canceled, nil, TimeNow())
+		c.StoreEvent(events2.Canceled, events2.CanceledData{Reason: cmd.Reason, Code: cmd.Code, Origin: cmd.Origin}, TimeNow())
 	case *MarkAsUnique:
 		c.StoreEvent(events2.Unique, nil, TimeNow())
+	case *MarkCustodianChecked:
+		c.StoreEvent(events2.CustodianChecked, nil, TimeNow())
 	case *StartSync:
 		c.StoreEvent(events2.SyncStarted, events2.SyncStartedData{SyncID: cmd.SyncID}, TimeNow())
+	case *MarkAsCompleted:
+		c.StoreEvent(events2.Completed, nil, TimeNow())
+	case *Expire:
+		c.StoreEvent(events2.Expired, nil, TimeNow())
+	case *Withdraw:
+		c.StoreEvent(events2.Withdrawn, events2.WithdrawnData{WithdrawnBy: cmd.WithdrawnBy, Reason: cmd.Reason}, TimeNow())
+	case *Amend:
+		return c.amend(cmd)
 	default:
 		return domain.ErrUnknownCommand
 	}
 	return nil
 }
 
+// amend stores the new version of the terms, it returns ErrInvalidAmendment when the terms do not change or are invalid
+func (c *ConsentAggregate) amend(cmd *Amend) error {
+	terms := c.Terms
+	changed := false
+	if cmd.End != nil && !cmd.End.Equal(terms.End) {
+		terms.End = *cmd.End
+		changed = true
+	}
+	if cmd.Class != "" && cmd.Class != terms.Class {
+		terms.Class = cmd.Class
+		changed = true
+	}
+	if cmd.PurposeOfUse != "" && cmd.PurposeOfUse != terms.PurposeOfUse {
+		terms.PurposeOfUse = cmd.PurposeOfUse
+		changed = true
+	}
+	if !changed {
+		return fmt.Errorf("%w: nothing to amend", domain.ErrInvalidAmendment)
+	}
+	if !terms.End.IsZero() && !terms.End.After(terms.Start) {
+		return fmt.Errorf("%w: end must be after start", domain.ErrInvalidAmendment)
+	}
+	c.StoreEvent(events2.Amended, events2.AmendedData{ProposedData: terms, Version: c.TermsVersion + 1}, TimeNow())
+	return nil
+}
+
+// checkErrorCode returns an error when a MarkAsErrored or Cancel command has a code which is not in the catalogue.
+// The code is optional.
+func checkErrorCode(command eh.Command) error {
+	var code domain.ErrorCode
+	switch cmd := command.(type) {
+	case *MarkAsErrored:
+		code = cmd.Code
+	case *Cancel:
+		code = cmd.Code
+	}
+	if code != "" && !code.Valid() {
+		return fmt.Errorf("%w: %s", domain.ErrUnknownErrorCode, code)
+	}
+	return nil
+}
+
 func (c *ConsentAggregate) ApplyEvent(ctx context.Context, event eh.Event) error {
 	log.Printf("[ConsentAggregate] event: %+v\n", event)
 	switch event.EventType() {
+	case events2.Proposed:
+		c.State = ConsentRequestPending
+		if data, ok := event.Data().(events2.ProposedData); ok {
+			c.Terms = data
+			c.TermsVersion = 1
+		}
+	case events2.Amended:
+		previous := c.Terms
+		c.State = ConsentRequestPending
+		c.Previous = &previous
+		if data, ok := event.Data().(events2.AmendedData); ok {
+			c.Terms = data.ProposedData
+			c.TermsVersion = data.Version
+		}
+	case events2.AmendmentRejected:
+		if c.Previous != nil {
+			c.Terms = *c.Previous
+		}
+		c.State = ConsentRequestCompleted
+		c.Previous = nil
+	case events2.Unique:
+		if c.State == ConsentRequestCustodianChecked {
+			c.State = ConsentRequestChecked
+		} else {
+			c.State = ConsentRequestUnique
+		}
+	case events2.CustodianChecked:
+		if c.State == ConsentRequestUnique {
+			c.State = ConsentRequestChecked
+		} else {
+			c.State = ConsentRequestCustodianChecked
+		}
+	case events2.SyncStarted:
+		c.State = ConsentRequestSyncing
+	case events2.Completed:
+		c.State = ConsentRequestCompleted
+		c.Previous = nil
+	case events2.Expired:
+		c.State = ConsentRequestExpired
+	case events2.Withdrawn:
+		c.State = ConsentRequestWithdrawn
 	case events2.Canceled:
 		c.State = ConsentRequestCanceled
 	case events2.Errored:
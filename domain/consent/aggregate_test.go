@@ -2,6 +2,8 @@ package consent
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/aggregatestore/events"
@@ -19,6 +21,13 @@ func TestConsentRequestAggregate_HandleCommand(t *testing.T) {
 	}
 
 	id := uuid.New()
+	terms := events2.ProposedData{ID: id, CustodianID: "agb:123", SubjectID: "bsn:999", ActorID: "agb:456", Class: "medication", PurposeOfUse: "TREAT", Start: TimeNow(), End: TimeNow().AddDate(1, 0, 0)}
+	extended := terms
+	extended.End = TimeNow().AddDate(2, 0, 0)
+	forResearch := terms
+	forResearch.Class = "lab-results"
+	forResearch.PurposeOfUse = "HRESCH"
+	beforeStart := TimeNow().AddDate(-1, 0, 0)
 	cases := map[string]struct {
 		agg            *ConsentAggregate
 		cmd            eh.Command
@@ -41,27 +50,258 @@ func TestConsentRequestAggregate_HandleCommand(t *testing.T) {
 				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
 			},
 			&Propose{
-				ID:          id,
-				CustodianID: "agb:123",
-				SubjectID:   "bsn:999",
-				ActorID:     "agb:456",
-				Start:       TimeNow(),
+				ID:           id,
+				CustodianID:  "agb:123",
+				SubjectID:    "bsn:999",
+				ActorID:      "agb:456",
+				Class:        "medication",
+				PurposeOfUse: "TREAT",
+				Start:        TimeNow(),
+				End:          TimeNow().AddDate(1, 0, 0),
 			}, []eh.Event{eh.NewEventForAggregate(events2.Proposed, events2.ProposedData{
-				ID:          id,
-				CustodianID: "agb:123",
-				SubjectID:   "bsn:999",
-				ActorID:     "agb:456",
-				Start:       TimeNow(),
-			},TimeNow(), ConsentAggregateType, id, 1)}, nil,
+				ID:           id,
+				CustodianID:  "agb:123",
+				SubjectID:    "bsn:999",
+				ActorID:      "agb:456",
+				Class:        "medication",
+				PurposeOfUse: "TREAT",
+				Start:        TimeNow(),
+				End:          TimeNow().AddDate(1, 0, 0),
+			}, TimeNow(), ConsentAggregateType, id, 1)}, nil,
 		},
 		"any command when cancelled": {
 			&ConsentAggregate{
 				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
-				State: ConsentRequestCanceled,
+				State:         ConsentRequestCanceled,
 			}, &mocks.Command{ID: id},
 			nil,
 			domain.ErrAggregateCancelled,
 		},
+		"mark as unique when pending": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestPending,
+			}, &MarkAsUnique{ID: id},
+			[]eh.Event{eh.NewEventForAggregate(events2.Unique, nil, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"mark custodian checked when unique": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestUnique,
+			}, &MarkCustodianChecked{ID: id},
+			[]eh.Event{eh.NewEventForAggregate(events2.CustodianChecked, nil, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"start sync when only unique": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestUnique,
+			}, &StartSync{ID: id, SyncID: uuid.New()},
+			nil,
+			domain.InvalidTransitionError{State: "unique", Command: "consent:start-sync"},
+		},
+		"start sync when checked": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestChecked,
+			}, &StartSync{ID: id, SyncID: id},
+			[]eh.Event{eh.NewEventForAggregate(events2.SyncStarted, events2.SyncStartedData{SyncID: id}, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"mark as completed when syncing": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestSyncing,
+			}, &MarkAsCompleted{ID: id},
+			[]eh.Event{eh.NewEventForAggregate(events2.Completed, nil, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"mark as completed when checked": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestChecked,
+			}, &MarkAsCompleted{ID: id},
+			nil,
+			domain.InvalidTransitionError{State: "checked", Command: "consent:mark-as-completed"},
+		},
+		"expire when completed": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestCompleted,
+			}, &Expire{ID: id},
+			[]eh.Event{eh.NewEventForAggregate(events2.Expired, nil, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"expire when syncing": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestSyncing,
+			}, &Expire{ID: id},
+			nil,
+			domain.InvalidTransitionError{State: "syncing", Command: "consent:expire"},
+		},
+		"withdraw when completed": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestCompleted,
+			}, &Withdraw{ID: id, WithdrawnBy: "bsn:999999990", Reason: "changed my mind"},
+			[]eh.Event{eh.NewEventForAggregate(events2.Withdrawn, events2.WithdrawnData{WithdrawnBy: "bsn:999999990", Reason: "changed my mind"}, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"withdraw when pending": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestPending,
+			}, &Withdraw{ID: id, WithdrawnBy: "bsn:999999990"},
+			nil,
+			domain.InvalidTransitionError{State: "pending", Command: "consent:withdraw"},
+		},
+		"amend when completed": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestCompleted,
+				Terms:         terms,
+				TermsVersion:  1,
+			}, &Amend{ID: id, End: &extended.End},
+			[]eh.Event{eh.NewEventForAggregate(events2.Amended, events2.AmendedData{ProposedData: extended, Version: 2}, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"amend class and purpose of use": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestCompleted,
+				Terms:         terms,
+				TermsVersion:  1,
+			}, &Amend{ID: id, Class: "lab-results", PurposeOfUse: "HRESCH"},
+			[]eh.Event{eh.NewEventForAggregate(events2.Amended, events2.AmendedData{ProposedData: forResearch, Version: 2}, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"amend without changes": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestCompleted,
+				Terms:         terms,
+				TermsVersion:  1,
+			}, &Amend{ID: id, End: &terms.End, Class: "medication"},
+			nil,
+			fmt.Errorf("%w: nothing to amend", domain.ErrInvalidAmendment),
+		},
+		"amend end before start": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestCompleted,
+				Terms:         terms,
+				TermsVersion:  1,
+			}, &Amend{ID: id, End: &beforeStart},
+			nil,
+			fmt.Errorf("%w: end must be after start", domain.ErrInvalidAmendment),
+		},
+		"amend when syncing": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestSyncing,
+			}, &Amend{ID: id, End: &extended.End},
+			nil,
+			domain.InvalidTransitionError{State: "syncing", Command: "consent:amend"},
+		},
+		"mark as errored when amending": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestSyncing,
+				Terms:         extended,
+				Previous:      &terms,
+			}, &MarkAsErrored{ID: id, Reason: "negotiation failed", Code: domain.ErrorCodeNegotiationFailed, Origin: "NegotiationSaga"},
+			[]eh.Event{eh.NewEventForAggregate(events2.AmendmentRejected, events2.AmendmentRejectedData{Reason: "negotiation failed", Code: domain.ErrorCodeNegotiationFailed, Origin: "NegotiationSaga"}, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"cancel when amending": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestPending,
+				Terms:         extended,
+				Previous:      &terms,
+			}, &Cancel{ID: id, Reason: "duplicate", Code: domain.ErrorCodeDuplicate, Origin: "ConsentUniquenessSaga"},
+			[]eh.Event{eh.NewEventForAggregate(events2.AmendmentRejected, events2.AmendmentRejectedData{Reason: "duplicate", Code: domain.ErrorCodeDuplicate, Origin: "ConsentUniquenessSaga"}, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"withdraw when amending": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestSyncing,
+				Terms:         extended,
+				Previous:      &terms,
+			}, &Withdraw{ID: id, WithdrawnBy: "bsn:999999990", Reason: "changed my mind"},
+			[]eh.Event{
+				eh.NewEventForAggregate(events2.AmendmentRejected, events2.AmendmentRejectedData{Reason: "consent withdrawn during the amendment", Code: domain.ErrorCodeWithdrawn, Origin: "consent:withdraw"}, TimeNow(), ConsentAggregateType, id, 1),
+				eh.NewEventForAggregate(events2.Withdrawn, events2.WithdrawnData{WithdrawnBy: "bsn:999999990", Reason: "changed my mind"}, TimeNow(), ConsentAggregateType, id, 2),
+			},
+			nil,
+		},
+		"mark as errored when syncing": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestSyncing,
+			}, &MarkAsErrored{ID: id, Reason: "negotiation failed", Code: domain.ErrorCodeNegotiationFailed, Origin: "NegotiationSaga"},
+			[]eh.Event{eh.NewEventForAggregate(events2.Errored, events2.ErroredData{Reason: "negotiation failed", Code: domain.ErrorCodeNegotiationFailed, Origin: "NegotiationSaga"}, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"cancel when pending": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestPending,
+			}, &Cancel{ID: id, Reason: "no longer needed", Origin: "api"},
+			[]eh.Event{eh.NewEventForAggregate(events2.Canceled, events2.CanceledData{Reason: "no longer needed", Origin: "api"}, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"cancel when syncing": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestSyncing,
+			}, &Cancel{ID: id, Reason: "no longer needed", Origin: "api"},
+			[]eh.Event{eh.NewEventForAggregate(events2.Canceled, events2.CanceledData{Reason: "no longer needed", Origin: "api"}, TimeNow(), ConsentAggregateType, id, 1)},
+			nil,
+		},
+		"propose twice": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestPending,
+			}, &Propose{ID: id, CustodianID: "agb:123", SubjectID: "bsn:999", ActorID: "agb:456", Start: TimeNow()},
+			nil,
+			domain.InvalidTransitionError{State: "pending", Command: "consent:propose"},
+		},
+		"start sync before unique": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestPending,
+			}, &StartSync{ID: id, SyncID: uuid.New()},
+			nil,
+			domain.InvalidTransitionError{State: "pending", Command: "consent:start-sync"},
+		},
+		"mark as errored with unknown code": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestSyncing,
+			}, &MarkAsErrored{ID: id, Reason: "negotiation failed", Code: "OOPS", Origin: "NegotiationSaga"},
+			nil,
+			fmt.Errorf("%w: OOPS", domain.ErrUnknownErrorCode),
+		},
+		"cancel with unknown code": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestPending,
+			}, &Cancel{ID: id, Reason: "no longer needed", Code: "OOPS", Origin: "api"},
+			nil,
+			fmt.Errorf("%w: OOPS", domain.ErrUnknownErrorCode),
+		},
+		"mark as errored when errored": {
+			&ConsentAggregate{
+				AggregateBase: events.NewAggregateBase(ConsentAggregateType, id),
+				State:         ConsentRequestErrored,
+			}, &MarkAsErrored{ID: id, Reason: "again"},
+			nil,
+			domain.InvalidTransitionError{State: "errored", Command: "consent:mark-as-errored"},
+		},
 	}
 
 	for name, testcase := range cases {
@@ -86,3 +326,73 @@ func TestConsentRequestAggregate_HandleCommand(t *testing.T) {
 
 	}
 }
+
+func TestConsentAggregate_ApplyEvent(t *testing.T) {
+	id := uuid.New()
+	cases := map[string]struct {
+		state         ConsentAggregateState
+		eventType     eh.EventType
+		expectedState ConsentAggregateState
+	}{
+		"proposed":               {ConsentRequestNew, events2.Proposed, ConsentRequestPending},
+		"unique":                 {ConsentRequestPending, events2.Unique, ConsentRequestUnique},
+		"custodian checked":      {ConsentRequestPending, events2.CustodianChecked, ConsentRequestCustodianChecked},
+		"unique after custodian": {ConsentRequestCustodianChecked, events2.Unique, ConsentRequestChecked},
+		"custodian after unique": {ConsentRequestUnique, events2.CustodianChecked, ConsentRequestChecked},
+		"sync started":           {ConsentRequestChecked, events2.SyncStarted, ConsentRequestSyncing},
+		"completed":              {ConsentRequestSyncing, events2.Completed, ConsentRequestCompleted},
+		"expired":                {ConsentRequestCompleted, events2.Expired, ConsentRequestExpired},
+		"withdrawn":              {ConsentRequestCompleted, events2.Withdrawn, ConsentRequestWithdrawn},
+		"canceled":               {ConsentRequestPending, events2.Canceled, ConsentRequestCanceled},
+		"errored":                {ConsentRequestSyncing, events2.Errored, ConsentRequestErrored},
+		"amended":                {ConsentRequestCompleted, events2.Amended, ConsentRequestPending},
+		"amendment rejected":     {ConsentRequestSyncing, events2.AmendmentRejected, ConsentRequestCompleted},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			agg := &ConsentAggregate{AggregateBase: events.NewAggregateBase(ConsentAggregateType, id), State: testcase.state}
+			event := eh.NewEventForAggregate(testcase.eventType, nil, TimeNow(), ConsentAggregateType, id, 1)
+			if err := agg.ApplyEvent(context.Background(), event); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if agg.State != testcase.expectedState {
+				t.Errorf("expected state '%s', got '%s'", testcase.expectedState, agg.State)
+			}
+		})
+	}
+}
+
+func TestConsentAggregate_ApplyEvent_AmendmentRejected(t *testing.T) {
+	id := uuid.New()
+	terms := events2.ProposedData{ID: id, Start: TimeNow(), End: TimeNow().AddDate(1, 0, 0)}
+	agg := &ConsentAggregate{AggregateBase: events.NewAggregateBase(ConsentAggregateType, id), State: ConsentRequestCompleted, Terms: terms, TermsVersion: 1}
+
+	amended := terms
+	amended.End = TimeNow().AddDate(2, 0, 0)
+	history := []eh.Event{
+		eh.NewEventForAggregate(events2.Amended, events2.AmendedData{ProposedData: amended, Version: 2}, TimeNow(), ConsentAggregateType, id, 6),
+		eh.NewEventForAggregate(events2.AmendmentRejected, events2.AmendmentRejectedData{Reason: "duplicate"}, TimeNow(), ConsentAggregateType, id, 7),
+	}
+	for _, event := range history {
+		if err := agg.ApplyEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if agg.State != ConsentRequestCompleted || agg.Previous != nil || !reflect.DeepEqual(agg.Terms, terms) {
+		t.Errorf("expected the previous version to be restored, got %s, %+v", agg.State, agg.Terms)
+	}
+	// the version number of the rejected amendment is not reused
+	if agg.TermsVersion != 2 {
+		t.Errorf("expected terms version 2, got %d", agg.TermsVersion)
+	}
+}
+
+func TestInvalidTransitionError(t *testing.T) {
+	agg := &ConsentAggregate{AggregateBase: events.NewAggregateBase(ConsentAggregateType, uuid.New())}
+	err := agg.HandleCommand(context.Background(), &MarkAsUnique{ID: agg.EntityID()})
+	if !errors.Is(err, domain.ErrInvalidTransition) {
+		t.Errorf("expected ErrInvalidTransition, got: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package consent
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"time"
+)
+
+const AmendCmdType = eh.CommandType("consent:amend")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &Amend{}
+	})
+}
+
+// Amend changes the terms of a completed consent. It creates a new version of the consent, which goes through
+// the checks and the negotiation again. The previous version stays in force until the new version is completed.
+type Amend struct {
+	ID uuid.UUID
+	// End is the new end of the validity period, the End is not changed when nil
+	End *time.Time `eh:"optional"`
+	// Class and PurposeOfUse replace the class and purpose of use of the consent, they are not changed when empty
+	Class        string `eh:"optional"`
+	PurposeOfUse string `eh:"optional"`
+}
+
+func (cmd Amend) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd Amend) AggregateType() eh.AggregateType {
+	return ConsentAggregateType
+}
+
+func (cmd Amend) CommandType() eh.CommandType {
+	return AmendCmdType
+}
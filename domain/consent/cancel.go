@@ -3,6 +3,7 @@ package consent
 import (
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
 )
 
 const CancelCmdType = eh.CommandType("consent:cancel")
@@ -10,6 +11,10 @@ const CancelCmdType = eh.CommandType("consent:cancel")
 type Cancel struct {
 	ID uuid.UUID
 	Reason string
+	// Code classifies the reason
+	Code domain.ErrorCode `eh:"optional"`
+	// Origin is the saga or other component which canceled the consent
+	Origin string `eh:"optional"`
 }
 
 func init() {
@@ -0,0 +1,193 @@
+package consent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/projector"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"time"
+)
+
+// Consent is the read model with the full lifecycle of a consent, as presented to clients
+type Consent struct {
+	ID          uuid.UUID
+	Status      ConsentAggregateState
+	CustodianID string
+	SubjectID   string
+	ActorID     string
+	// Class is the category of data the consent covers and PurposeOfUse the HL7 PurposeOfUse code
+	Class        string
+	PurposeOfUse string
+	// Start and End are the validity period, a zero End means the consent does not expire
+	Start time.Time
+	End   time.Time
+	// TermsVersion is the version of the terms above, the proposed consent is version 1 and every amendment adds one
+	TermsVersion int
+	// Previous is the version in force while an amendment is in progress, nil otherwise
+	Previous *ConsentVersion
+	SyncID   uuid.UUID
+	// Reason, ErrorCode and Origin tell why and by whom the consent was errored or canceled
+	Reason    string
+	ErrorCode domain.ErrorCode
+	Origin    string
+	// WithdrawnBy and WithdrawnAt tell who withdrew the consent and when, the Reason is set as well
+	WithdrawnBy string
+	WithdrawnAt time.Time
+	// Transitions lists every event applied to the consent in order, an amendment repeats the checks and the sync
+	Transitions []Transition
+	// Versions lists every completed version of the terms in order, the last one is the version in force
+	Versions  []ConsentVersion
+	Version   int
+	UpdatedAt time.Time
+}
+
+// Transition is an event applied to the consent, TermsVersion is the version of the terms when it was applied
+type Transition struct {
+	EventType    eh.EventType
+	At           time.Time
+	TermsVersion int
+}
+
+// ConsentVersion is a completed version of the terms of a consent
+type ConsentVersion struct {
+	TermsVersion int
+	Class        string
+	PurposeOfUse string
+	Start        time.Time
+	End          time.Time
+}
+
+var _ = eh.Versionable(&Consent{})
+var _ = eh.Entity(&Consent{})
+
+func (entity Consent) AggregateVersion() int {
+	return entity.Version
+}
+
+func (entity Consent) EntityID() uuid.UUID {
+	return entity.ID
+}
+
+// ConsentProjector projects every event of the ConsentAggregate into the Consent read model
+type ConsentProjector struct {
+}
+
+func (p ConsentProjector) Project(ctx context.Context, event eh.Event, entity eh.Entity) (eh.Entity, error) {
+	model, ok := entity.(*Consent)
+	if !ok {
+		return nil, errors.New("model is of incorrect type")
+	}
+
+	switch event.EventType() {
+	case events.Proposed:
+		data, ok := event.Data().(events.ProposedData)
+		if !ok {
+			return nil, errors.New("event data of wrong type")
+		}
+		model.ID = event.AggregateID()
+		model.CustodianID = data.CustodianID
+		model.SubjectID = data.SubjectID
+		model.ActorID = data.ActorID
+		model.Class = data.Class
+		model.PurposeOfUse = data.PurposeOfUse
+		model.Start = data.Start
+		model.End = data.End
+		model.TermsVersion = 1
+		model.Status = ConsentRequestPending
+	case events.Amended:
+		data, ok := event.Data().(events.AmendedData)
+		if !ok {
+			return nil, errors.New("event data of wrong type")
+		}
+		previous := model.terms()
+		model.Previous = &previous
+		model.Class = data.Class
+		model.PurposeOfUse = data.PurposeOfUse
+		model.Start = data.Start
+		model.End = data.End
+		model.TermsVersion = data.Version
+		model.Status = ConsentRequestPending
+		model.Reason, model.ErrorCode, model.Origin = "", "", ""
+	case events.AmendmentRejected:
+		if previous := model.Previous; previous != nil {
+			model.Class, model.PurposeOfUse = previous.Class, previous.PurposeOfUse
+			model.Start, model.End, model.TermsVersion = previous.Start, previous.End, previous.TermsVersion
+		}
+		model.Previous = nil
+		model.Status = ConsentRequestCompleted
+		if data, ok := event.Data().(events.AmendmentRejectedData); ok {
+			model.Reason, model.ErrorCode, model.Origin = data.Reason, data.Code, data.Origin
+		}
+	case events.Unique:
+		if model.Status == ConsentRequestCustodianChecked {
+			model.Status = ConsentRequestChecked
+		} else {
+			model.Status = ConsentRequestUnique
+		}
+	case events.CustodianChecked:
+		if model.Status == ConsentRequestUnique {
+			model.Status = ConsentRequestChecked
+		} else {
+			model.Status = ConsentRequestCustodianChecked
+		}
+	case events.SyncStarted:
+		data, ok := event.Data().(events.SyncStartedData)
+		if !ok {
+			return nil, errors.New("event data of wrong type")
+		}
+		model.SyncID = data.SyncID
+		model.Status = ConsentRequestSyncing
+	case events.Completed:
+		model.Status = ConsentRequestCompleted
+		model.Previous = nil
+		model.Versions = append(model.Versions, model.terms())
+	case events.Expired:
+		model.Status = ConsentRequestExpired
+	case events.Withdrawn:
+		data, ok := event.Data().(events.WithdrawnData)
+		if !ok {
+			return nil, errors.New("event data of wrong type")
+		}
+		model.Status = ConsentRequestWithdrawn
+		model.Reason = data.Reason
+		model.WithdrawnBy = data.WithdrawnBy
+		model.WithdrawnAt = event.Timestamp()
+	case events.Errored:
+		model.Status = ConsentRequestErrored
+		// events stored before the reason was persisted have no data
+		if data, ok := event.Data().(events.ErroredData); ok {
+			model.Reason, model.ErrorCode, model.Origin = data.Reason, data.Code, data.Origin
+		}
+	case events.Canceled:
+		model.Status = ConsentRequestCanceled
+		if data, ok := event.Data().(events.CanceledData); ok {
+			model.Reason, model.ErrorCode, model.Origin = data.Reason, data.Code, data.Origin
+		}
+	default:
+		return nil, fmt.Errorf("could not project event: %s", event.EventType())
+	}
+
+	model.Transitions = append(model.Transitions, Transition{EventType: event.EventType(), At: event.Timestamp(), TermsVersion: model.TermsVersion})
+	model.Version++
+	model.UpdatedAt = TimeNow()
+	return model, nil
+}
+
+// terms returns the current terms of the consent
+func (entity Consent) terms() ConsentVersion {
+	return ConsentVersion{
+		TermsVersion: entity.TermsVersion,
+		Class:        entity.Class,
+		PurposeOfUse: entity.PurposeOfUse,
+		Start:        entity.Start,
+		End:          entity.End,
+	}
+}
+
+func (p ConsentProjector) ProjectorType() projector.Type {
+	return projector.Type("consent-projector")
+}
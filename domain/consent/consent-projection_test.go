@@ -0,0 +1,208 @@
+package consent
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	events2 "github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConsentProjector_Project(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2017, time.July, 10, 23, 0, 0, 0, time.UTC)
+	}
+	at := func(minutes int) time.Time {
+		return time.Date(2020, time.January, 1, 12, minutes, 0, 0, time.UTC)
+	}
+
+	id := uuid.New()
+	syncID := uuid.New()
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+	proposed := eh.NewEventForAggregate(events2.Proposed, events2.ProposedData{ID: id, CustodianID: "agb:123", SubjectID: "bsn:999999990", ActorID: "agb:456", Class: "medication", PurposeOfUse: "TREAT", Start: start, End: end}, at(0), ConsentAggregateType, id, 1)
+	unique := eh.NewEventForAggregate(events2.Unique, nil, at(1), ConsentAggregateType, id, 2)
+	custodianChecked := eh.NewEventForAggregate(events2.CustodianChecked, nil, at(2), ConsentAggregateType, id, 3)
+	syncStarted := eh.NewEventForAggregate(events2.SyncStarted, events2.SyncStartedData{SyncID: syncID}, at(3), ConsentAggregateType, id, 4)
+	completed := eh.NewEventForAggregate(events2.Completed, nil, at(4), ConsentAggregateType, id, 5)
+	expired := eh.NewEventForAggregate(events2.Expired, nil, at(5), ConsentAggregateType, id, 6)
+	withdrawn := eh.NewEventForAggregate(events2.Withdrawn, events2.WithdrawnData{WithdrawnBy: "bsn:999999990", Reason: "changed my mind"}, at(5), ConsentAggregateType, id, 6)
+	errored := eh.NewEventForAggregate(events2.Errored, events2.ErroredData{Reason: "negotiation failed: rejected", Code: domain.ErrorCodeContractRejected, Origin: "NegotiationSaga"}, at(4), ConsentAggregateType, id, 5)
+	amendedEnd := end.AddDate(1, 0, 0)
+	amended := eh.NewEventForAggregate(events2.Amended, events2.AmendedData{ProposedData: events2.ProposedData{ID: id, CustodianID: "agb:123", SubjectID: "bsn:999999990", ActorID: "agb:456", Class: "lab-results", PurposeOfUse: "TREAT", Start: start, End: amendedEnd}, Version: 2}, at(5), ConsentAggregateType, id, 6)
+	amendmentCompleted := eh.NewEventForAggregate(events2.Completed, nil, at(6), ConsentAggregateType, id, 11)
+	amendmentRejected := eh.NewEventForAggregate(events2.AmendmentRejected, events2.AmendmentRejectedData{Reason: "duplicate", Code: domain.ErrorCodeDuplicate, Origin: "ConsentUniquenessSaga"}, at(6), ConsentAggregateType, id, 7)
+	canceled := eh.NewEventForAggregate(events2.Canceled, events2.CanceledData{Reason: "duplicate", Code: domain.ErrorCodeDuplicate, Origin: "ConsentUniquenessSaga"}, at(1), ConsentAggregateType, id, 2)
+
+	v1 := ConsentVersion{TermsVersion: 1, Class: "medication", PurposeOfUse: "TREAT", Start: start, End: end}
+	v2 := ConsentVersion{TermsVersion: 2, Class: "lab-results", PurposeOfUse: "TREAT", Start: start, End: amendedEnd}
+	// transitions returns the transitions of the events, applied to the given version of the terms
+	transitions := func(termsVersion int, history ...eh.Event) []Transition {
+		var result []Transition
+		for _, event := range history {
+			result = append(result, Transition{EventType: event.EventType(), At: event.Timestamp(), TermsVersion: termsVersion})
+		}
+		return result
+	}
+
+	base := Consent{
+		ID:           id,
+		CustodianID:  "agb:123",
+		SubjectID:    "bsn:999999990",
+		ActorID:      "agb:456",
+		Class:        "medication",
+		PurposeOfUse: "TREAT",
+		Start:        start,
+		End:          end,
+		TermsVersion: 1,
+		UpdatedAt:    TimeNow(),
+	}
+
+	cases := map[string]struct {
+		history  []eh.Event
+		expected func(Consent) Consent
+	}{
+		"proposed": {
+			[]eh.Event{proposed},
+			func(c Consent) Consent {
+				c.Status = ConsentRequestPending
+				c.Transitions = transitions(1, proposed)
+				c.Version = 1
+				return c
+			},
+		},
+		"completed": {
+			[]eh.Event{proposed, unique, custodianChecked, syncStarted, completed},
+			func(c Consent) Consent {
+				c.Status = ConsentRequestCompleted
+				c.SyncID = syncID
+				c.Transitions = transitions(1, proposed, unique, custodianChecked, syncStarted, completed)
+				c.Versions = []ConsentVersion{v1}
+				c.Version = 5
+				return c
+			},
+		},
+		"expired": {
+			[]eh.Event{proposed, unique, custodianChecked, syncStarted, completed, expired},
+			func(c Consent) Consent {
+				c.Status = ConsentRequestExpired
+				c.SyncID = syncID
+				c.Transitions = transitions(1, proposed, unique, custodianChecked, syncStarted, completed, expired)
+				c.Versions = []ConsentVersion{v1}
+				c.Version = 6
+				return c
+			},
+		},
+		"withdrawn": {
+			[]eh.Event{proposed, unique, custodianChecked, syncStarted, completed, withdrawn},
+			func(c Consent) Consent {
+				c.Status = ConsentRequestWithdrawn
+				c.SyncID = syncID
+				c.Reason = "changed my mind"
+				c.WithdrawnBy = "bsn:999999990"
+				c.WithdrawnAt = at(5)
+				c.Transitions = transitions(1, proposed, unique, custodianChecked, syncStarted, completed, withdrawn)
+				c.Versions = []ConsentVersion{v1}
+				c.Version = 6
+				return c
+			},
+		},
+		"amendment in progress": {
+			[]eh.Event{proposed, unique, custodianChecked, syncStarted, completed, amended},
+			func(c Consent) Consent {
+				c.Status = ConsentRequestPending
+				c.SyncID = syncID
+				c.Class = "lab-results"
+				c.End = amendedEnd
+				c.TermsVersion = 2
+				c.Previous = &v1
+				c.Transitions = append(transitions(1, proposed, unique, custodianChecked, syncStarted, completed), transitions(2, amended)...)
+				c.Versions = []ConsentVersion{v1}
+				c.Version = 6
+				return c
+			},
+		},
+		"amendment completed": {
+			[]eh.Event{proposed, unique, custodianChecked, syncStarted, completed, amended, unique, custodianChecked, syncStarted, amendmentCompleted},
+			func(c Consent) Consent {
+				c.Status = ConsentRequestCompleted
+				c.SyncID = syncID
+				c.Class = "lab-results"
+				c.End = amendedEnd
+				c.TermsVersion = 2
+				c.Transitions = append(transitions(1, proposed, unique, custodianChecked, syncStarted, completed), transitions(2, amended, unique, custodianChecked, syncStarted, amendmentCompleted)...)
+				c.Versions = []ConsentVersion{v1, v2}
+				c.Version = 10
+				return c
+			},
+		},
+		"amendment rejected": {
+			[]eh.Event{proposed, unique, custodianChecked, syncStarted, completed, amended, amendmentRejected},
+			func(c Consent) Consent {
+				c.Status = ConsentRequestCompleted
+				c.SyncID = syncID
+				c.Reason = "duplicate"
+				c.ErrorCode = domain.ErrorCodeDuplicate
+				c.Origin = "ConsentUniquenessSaga"
+				c.Transitions = append(transitions(1, proposed, unique, custodianChecked, syncStarted, completed), transitions(2, amended)...)
+				c.Transitions = append(c.Transitions, transitions(1, amendmentRejected)...)
+				c.Versions = []ConsentVersion{v1}
+				c.Version = 7
+				return c
+			},
+		},
+		"errored while syncing": {
+			[]eh.Event{proposed, custodianChecked, unique, syncStarted, errored},
+			func(c Consent) Consent {
+				c.Status = ConsentRequestErrored
+				c.SyncID = syncID
+				c.Reason = "negotiation failed: rejected"
+				c.ErrorCode = domain.ErrorCodeContractRejected
+				c.Origin = "NegotiationSaga"
+				c.Transitions = transitions(1, proposed, custodianChecked, unique, syncStarted, errored)
+				c.Version = 5
+				return c
+			},
+		},
+		"canceled as duplicate": {
+			[]eh.Event{proposed, canceled},
+			func(c Consent) Consent {
+				c.Status = ConsentRequestCanceled
+				c.Reason = "duplicate"
+				c.ErrorCode = domain.ErrorCodeDuplicate
+				c.Origin = "ConsentUniquenessSaga"
+				c.Transitions = transitions(1, proposed, canceled)
+				c.Version = 2
+				return c
+			},
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			var entity eh.Entity = &Consent{}
+			for _, event := range testcase.history {
+				var err error
+				if entity, err = (ConsentProjector{}).Project(context.Background(), event, entity); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected := testcase.expected(base)
+			if !reflect.DeepEqual(*entity.(*Consent), expected) {
+				t.Errorf("incorrect read model")
+				t.Logf("exp: %+v", expected)
+				t.Logf("got: %+v", *entity.(*Consent))
+			}
+		})
+	}
+}
+
+func TestConsentProjector_UnknownEvent(t *testing.T) {
+	event := eh.NewEventForAggregate(eh.EventType("consent:unknown"), nil, TimeNow(), ConsentAggregateType, uuid.New(), 1)
+	if _, err := (ConsentProjector{}).Project(context.Background(), event, &Consent{}); err == nil {
+		t.Error("expected an error for an unknown event")
+	}
+}
@@ -0,0 +1,31 @@
+package consent
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const ExpireCmdType = eh.CommandType("consent:expire")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &Expire{}
+	})
+}
+
+// Expire ends a completed consent of which the validity period has ended
+type Expire struct {
+	ID uuid.UUID
+}
+
+func (cmd Expire) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd Expire) AggregateType() eh.AggregateType {
+	return ConsentAggregateType
+}
+
+func (cmd Expire) CommandType() eh.CommandType {
+	return ExpireCmdType
+}
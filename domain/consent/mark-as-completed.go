@@ -0,0 +1,31 @@
+package consent
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const MarkAsCompletedCmdType = eh.CommandType("consent:mark-as-completed")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &MarkAsCompleted{}
+	})
+}
+
+// MarkAsCompleted completes a consent of which all parties have signed the contract
+type MarkAsCompleted struct {
+	ID uuid.UUID
+}
+
+func (cmd MarkAsCompleted) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd MarkAsCompleted) AggregateType() eh.AggregateType {
+	return ConsentAggregateType
+}
+
+func (cmd MarkAsCompleted) CommandType() eh.CommandType {
+	return MarkAsCompletedCmdType
+}
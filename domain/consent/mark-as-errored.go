@@ -3,6 +3,7 @@ package consent
 import (
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
 )
 
 const MarkAsErroredCmdType = eh.CommandType("consent:mark-as-errored")
@@ -16,6 +17,10 @@ func init() {
 type MarkAsErrored struct {
 	ID     uuid.UUID
 	Reason string
+	// Code classifies the reason
+	Code domain.ErrorCode `eh:"optional"`
+	// Origin is the saga or other component which marked the consent as errored
+	Origin string `eh:"optional"`
 }
 
 func (cmd MarkAsErrored) AggregateID() uuid.UUID {
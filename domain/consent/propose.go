@@ -15,10 +15,13 @@ type Propose struct {
 	ActorID     string
 	//InitiatorID string    // party(care provider or subject) who started this consent request
 	//InitiatedAt time.Time // time this consent request was initiated at the initiator
-	//Class       string
 	//Proof       string
-	Start       time.Time
-	End         time.Time `eh:"optional"`
+	// Class is the category of data the consent covers and PurposeOfUse the HL7 PurposeOfUse code,
+	// both must be in the configured code list
+	Class        string
+	PurposeOfUse string
+	Start        time.Time
+	End          time.Time `eh:"optional"`
 }
 
 func init() {
@@ -7,20 +7,35 @@ import (
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/eventhandler/projector"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
 	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain/negotiation"
 	"log"
 	"time"
 )
 
 type ConsentNegotiation struct {
-	ID        uuid.UUID
-	SyncID    uuid.UUID
-	PartyIDs  []string
+	ID               uuid.UUID
+	SyncID           uuid.UUID
+	Parties          []negotiation.Party
+	Unique           bool
+	CustodianChecked bool
+	// Status is the state of the consent as far as it is known to the read model
+	Status ConsentAggregateState
+	// Reason, ErrorCode and Origin tell why and by whom the consent was errored or canceled
+	Reason    string
+	ErrorCode domain.ErrorCode
+	Origin    string
 	Version   int
 	UpdatedAt time.Time
 	Contract  string
 }
 
+// Checked returns true when both the uniqueness and custodian checks have passed
+func (entity ConsentNegotiation) Checked() bool {
+	return entity.Unique && entity.CustodianChecked
+}
+
 var _ = eh.Versionable(&ConsentNegotiation{})
 var _ = eh.Entity(&ConsentNegotiation{})
 
@@ -49,15 +64,57 @@ func (p SyncProjector) Project(ctx context.Context, event eh.Event, entity eh.En
 			return nil, errors.New("event data of wrong type")
 		}
 		model.ID = event.AggregateID()
-		model.Contract = fmt.Sprintf("custodian:%s,actor:%s,subject:%s", data.CustodianID, data.ActorID, data.SubjectID)
-		model.PartyIDs = append(model.PartyIDs, data.SubjectID, data.CustodianID, data.ActorID)
-	//case events.Unique:
+		model.Status = ConsentRequestPending
+		model.Contract = contract(data)
+		model.Parties = append(model.Parties,
+			negotiation.Party{ID: data.SubjectID, Role: negotiation.SubjectRole},
+			negotiation.Party{ID: data.CustodianID, Role: negotiation.CustodianRole},
+			negotiation.Party{ID: data.ActorID, Role: negotiation.ActorRole},
+		)
+	case events.Amended:
+		// the new version is checked and negotiated again
+		if data, ok := event.Data().(events.AmendedData); ok {
+			model.Contract = contract(data.ProposedData)
+		}
+		model.Unique = false
+		model.CustodianChecked = false
+		model.Status = ConsentRequestPending
+		model.Reason, model.ErrorCode, model.Origin = "", "", ""
+	case events.AmendmentRejected:
+		model.Status = ConsentRequestCompleted
+		if data, ok := event.Data().(events.AmendmentRejectedData); ok {
+			model.Reason, model.ErrorCode, model.Origin = data.Reason, data.Code, data.Origin
+		}
+	case events.Unique:
+		model.Unique = true
+		model.Status = checkStatus(*model)
+	case events.CustodianChecked:
+		model.CustodianChecked = true
+		model.Status = checkStatus(*model)
 	case events.SyncStarted:
 		data, ok := event.Data().(events.SyncStartedData)
 		if !ok {
 			return nil, errors.New("event data of wrong type")
 		}
 		model.SyncID = data.SyncID
+		model.Status = ConsentRequestSyncing
+	case events.Completed:
+		model.Status = ConsentRequestCompleted
+	case events.Expired:
+		model.Status = ConsentRequestExpired
+	case events.Withdrawn:
+		model.Status = ConsentRequestWithdrawn
+	case events.Errored:
+		model.Status = ConsentRequestErrored
+		// events stored before the reason was persisted have no data
+		if data, ok := event.Data().(events.ErroredData); ok {
+			model.Reason, model.ErrorCode, model.Origin = data.Reason, data.Code, data.Origin
+		}
+	case events.Canceled:
+		model.Status = ConsentRequestCanceled
+		if data, ok := event.Data().(events.CanceledData); ok {
+			model.Reason, model.ErrorCode, model.Origin = data.Reason, data.Code, data.Origin
+		}
 	default:
 		//return model, fmt.Errorf("could not project event: %s", event.EventType())
 		log.Printf("could not project event: %s\n", event.EventType())
@@ -67,6 +124,33 @@ func (p SyncProjector) Project(ctx context.Context, event eh.Event, entity eh.En
 	return model, nil
 }
 
+// contract returns the contract the parties sign for the terms of a consent, including its validity period.
+// An open ended consent has an empty end.
+func contract(data events.ProposedData) string {
+	return fmt.Sprintf("custodian:%s,actor:%s,subject:%s,class:%s,purposeOfUse:%s,start:%s,end:%s",
+		data.CustodianID, data.ActorID, data.SubjectID, data.Class, data.PurposeOfUse, formatTime(data.Start), formatTime(data.End))
+}
+
+// formatTime returns t in RFC 3339 and UTC, or an empty string when t is not set
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// checkStatus returns the status of a consent of which one or both checks have passed
+func checkStatus(model ConsentNegotiation) ConsentAggregateState {
+	switch {
+	case model.Checked():
+		return ConsentRequestChecked
+	case model.Unique:
+		return ConsentRequestUnique
+	default:
+		return ConsentRequestCustodianChecked
+	}
+}
+
 func (p SyncProjector) ProjectorType() projector.Type {
 	return projector.Type("sync-projector")
 }
@@ -0,0 +1,137 @@
+package consent
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	events2 "github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"testing"
+	"time"
+)
+
+func TestSyncProjector_Status(t *testing.T) {
+	id := uuid.New()
+	cases := map[string]struct {
+		history        []eh.EventType
+		expectedStatus ConsentAggregateState
+	}{
+		"unique":    {[]eh.EventType{events2.Unique}, ConsentRequestUnique},
+		"custodian": {[]eh.EventType{events2.CustodianChecked}, ConsentRequestCustodianChecked},
+		"checked":   {[]eh.EventType{events2.CustodianChecked, events2.Unique}, ConsentRequestChecked},
+		"completed": {[]eh.EventType{events2.Unique, events2.CustodianChecked, events2.SyncStarted, events2.Completed}, ConsentRequestCompleted},
+		"errored":   {[]eh.EventType{events2.Unique, events2.Errored}, ConsentRequestErrored},
+		"canceled":  {[]eh.EventType{events2.Canceled}, ConsentRequestCanceled},
+		"amended":   {[]eh.EventType{events2.Unique, events2.CustodianChecked, events2.SyncStarted, events2.Completed, events2.Amended, events2.Unique}, ConsentRequestUnique},
+		"rejected":  {[]eh.EventType{events2.Unique, events2.CustodianChecked, events2.SyncStarted, events2.Completed, events2.Amended, events2.AmendmentRejected}, ConsentRequestCompleted},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			var entity eh.Entity = &ConsentNegotiation{}
+			history := []eh.Event{eh.NewEventForAggregate(events2.Proposed, events2.ProposedData{ID: id}, TimeNow(), ConsentAggregateType, id, 1)}
+			for i, eventType := range testcase.history {
+				var data eh.EventData
+				if eventType == events2.SyncStarted {
+					data = events2.SyncStartedData{SyncID: uuid.New()}
+				}
+				history = append(history, eh.NewEventForAggregate(eventType, data, TimeNow(), ConsentAggregateType, id, i+2))
+			}
+			for _, event := range history {
+				var err error
+				if entity, err = (SyncProjector{}).Project(context.Background(), event, entity); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if status := entity.(*ConsentNegotiation).Status; status != testcase.expectedStatus {
+				t.Errorf("expected status '%s', got '%s'", testcase.expectedStatus, status)
+			}
+		})
+	}
+}
+
+func TestSyncProjector_Reason(t *testing.T) {
+	id := uuid.New()
+	cases := map[string]struct {
+		event          eh.Event
+		expectedReason string
+		expectedCode   domain.ErrorCode
+		expectedOrigin string
+	}{
+		"errored": {
+			eh.NewEventForAggregate(events2.Errored, events2.ErroredData{Reason: "checks did not pass", Code: domain.ErrorCodeCheckTimeout, Origin: "ChecksProcessManager"}, TimeNow(), ConsentAggregateType, id, 2),
+			"checks did not pass", domain.ErrorCodeCheckTimeout, "ChecksProcessManager",
+		},
+		"canceled": {
+			eh.NewEventForAggregate(events2.Canceled, events2.CanceledData{Reason: "no longer needed", Origin: "api"}, TimeNow(), ConsentAggregateType, id, 2),
+			"no longer needed", "", "api",
+		},
+		"errored without data": {
+			eh.NewEventForAggregate(events2.Errored, nil, TimeNow(), ConsentAggregateType, id, 2),
+			"", "", "",
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			entity, err := SyncProjector{}.Project(context.Background(), testcase.event, &ConsentNegotiation{ID: id, Version: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			model := entity.(*ConsentNegotiation)
+			if model.Reason != testcase.expectedReason || model.ErrorCode != testcase.expectedCode || model.Origin != testcase.expectedOrigin {
+				t.Errorf("expected reason '%s', code '%s' and origin '%s', got: %+v", testcase.expectedReason, testcase.expectedCode, testcase.expectedOrigin, model)
+			}
+		})
+	}
+}
+
+func TestSyncProjector_Contract(t *testing.T) {
+	id := uuid.New()
+	start := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	terms := events2.ProposedData{ID: id, CustodianID: "agb:123", SubjectID: "bsn:999999990", ActorID: "agb:456", Class: "medication", PurposeOfUse: "TREAT", Start: start, End: start.AddDate(1, 0, 0)}
+
+	var entity eh.Entity = &ConsentNegotiation{}
+	var err error
+	if entity, err = (SyncProjector{}).Project(context.Background(), eh.NewEventForAggregate(events2.Proposed, terms, TimeNow(), ConsentAggregateType, id, 1), entity); err != nil {
+		t.Fatal(err)
+	}
+	expected := "custodian:agb:123,actor:agb:456,subject:bsn:999999990,class:medication,purposeOfUse:TREAT,start:2020-01-01T12:00:00Z,end:2021-01-01T12:00:00Z"
+	if contract := entity.(*ConsentNegotiation).Contract; contract != expected {
+		t.Errorf("expected contract '%s', got '%s'", expected, contract)
+	}
+
+	// the amended version is negotiated with a new contract
+	cases := map[string]struct {
+		amend    func(terms *events2.ProposedData)
+		expected string
+	}{
+		"class": {
+			func(terms *events2.ProposedData) { terms.Class = "lab-results" },
+			"custodian:agb:123,actor:agb:456,subject:bsn:999999990,class:lab-results,purposeOfUse:TREAT,start:2020-01-01T12:00:00Z,end:2021-01-01T12:00:00Z",
+		},
+		"end only": {
+			func(terms *events2.ProposedData) { terms.End = start.AddDate(2, 0, 0) },
+			"custodian:agb:123,actor:agb:456,subject:bsn:999999990,class:medication,purposeOfUse:TREAT,start:2020-01-01T12:00:00Z,end:2022-01-01T12:00:00Z",
+		},
+		"end removed": {
+			func(terms *events2.ProposedData) { terms.End = time.Time{} },
+			"custodian:agb:123,actor:agb:456,subject:bsn:999999990,class:medication,purposeOfUse:TREAT,start:2020-01-01T12:00:00Z,end:",
+		},
+	}
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			amended := terms
+			testcase.amend(&amended)
+			model := *entity.(*ConsentNegotiation)
+			event := eh.NewEventForAggregate(events2.Amended, events2.AmendedData{ProposedData: amended, Version: 2}, TimeNow(), ConsentAggregateType, id, 2)
+			result, err := (SyncProjector{}).Project(context.Background(), event, &model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contract := result.(*ConsentNegotiation).Contract; contract != testcase.expected {
+				t.Errorf("expected contract '%s', got '%s'", testcase.expected, contract)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package consent
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const WithdrawCmdType = eh.CommandType("consent:withdraw")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &Withdraw{}
+	})
+}
+
+// Withdraw ends a completed consent on request of WithdrawnBy, usually the subject.
+// Unlike Cancel, which stops a consent before it is synced, the withdrawal is propagated to all parties.
+type Withdraw struct {
+	ID          uuid.UUID
+	WithdrawnBy string
+	Reason      string `eh:"optional"`
+}
+
+func (cmd Withdraw) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd Withdraw) AggregateType() eh.AggregateType {
+	return ConsentAggregateType
+}
+
+func (cmd Withdraw) CommandType() eh.CommandType {
+	return WithdrawCmdType
+}
@@ -0,0 +1,31 @@
+package deadline
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const CancelDeadlineCmdType = eh.CommandType("deadline:cancel")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &CancelDeadline{}
+	})
+}
+
+// CancelDeadline cancels a scheduled deadline, canceling a deadline which is not scheduled is a no-op
+type CancelDeadline struct {
+	ID uuid.UUID
+}
+
+func (cmd CancelDeadline) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd CancelDeadline) AggregateType() eh.AggregateType {
+	return DeadlineAggregateType
+}
+
+func (cmd CancelDeadline) CommandType() eh.CommandType {
+	return CancelDeadlineCmdType
+}
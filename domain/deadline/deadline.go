@@ -0,0 +1,119 @@
+package deadline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	events2 "github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"log"
+	"time"
+)
+
+const DeadlineAggregateType = eh.AggregateType("deadline")
+
+func init() {
+	eh.RegisterAggregate(func(id uuid.UUID) eh.Aggregate {
+		return &DeadlineAggregate{
+			AggregateBase: events.NewAggregateBase(DeadlineAggregateType, id),
+		}
+	})
+}
+
+type DeadlineState = domain.State
+
+// DeadlineNew is the state of an aggregate without any events
+const DeadlineNew = DeadlineState("")
+const DeadlineScheduled = DeadlineState("scheduled")
+const DeadlineCanceled = DeadlineState("canceled")
+const DeadlineFired = DeadlineState("fired")
+
+// allowedTransitions lists per command the states in which the aggregate accepts it.
+// CancelDeadline is accepted in every state, see HandleCommand.
+// A deadline which was canceled or fired can be scheduled again, e.g. for the negotiation of an amended consent.
+var allowedTransitions = domain.Transitions{
+	ScheduleDeadlineCmdType: {DeadlineNew, DeadlineCanceled, DeadlineFired},
+	FireDeadlineCmdType:     {DeadlineScheduled},
+}
+
+var TimeNow = func() time.Time {
+	return time.Now()
+}
+
+// namespace is the namespace of the IDs returned by ID
+var namespace = uuid.MustParse("3c3a3b8c-6d0e-4c8e-9a53-8d2f0b2f6a41")
+
+// ID returns the ID of the deadline with the given name for an aggregate.
+// It allows a saga to cancel the deadline it scheduled without keeping track of its ID.
+func ID(aggregateID uuid.UUID, name string) uuid.UUID {
+	return uuid.NewSHA1(namespace, []byte(aggregateID.String()+"/"+name))
+}
+
+// DeadlineAggregate is a command which is dispatched when its deadline passes, unless it is canceled before.
+// The Scheduler keeps track of the scheduled deadlines and fires them.
+type DeadlineAggregate struct {
+	*events.AggregateBase
+
+	State DeadlineState
+}
+
+func (d *DeadlineAggregate) HandleCommand(ctx context.Context, command eh.Command) error {
+	log.Printf("[DeadlineAggregate] command: %v, %+v\n", command.CommandType(), command)
+
+	// Canceling is idempotent, so a saga can cancel a deadline without knowing whether it was scheduled or fired
+	if _, ok := command.(*CancelDeadline); ok {
+		if d.State == DeadlineScheduled {
+			d.StoreEvent(events2.DeadlineCanceled, nil, TimeNow())
+		}
+		return nil
+	}
+
+	if err := allowedTransitions.Check(d.State, command.CommandType()); err != nil {
+		return err
+	}
+
+	switch cmd := command.(type) {
+	case *ScheduleDeadline:
+		raw, err := json.Marshal(cmd.Command)
+		if err != nil {
+			return fmt.Errorf("could not encode the command of the deadline: %w", err)
+		}
+		d.StoreEvent(events2.DeadlineScheduled, events2.DeadlineScheduledData{
+			Deadline:    cmd.Deadline,
+			CommandType: cmd.Command.CommandType(),
+			Command:     raw,
+		}, TimeNow())
+	case *FireDeadline:
+		d.StoreEvent(events2.DeadlineFired, nil, TimeNow())
+	default:
+		return domain.ErrUnknownCommand
+	}
+	return nil
+}
+
+func (d *DeadlineAggregate) ApplyEvent(ctx context.Context, event eh.Event) error {
+	switch event.EventType() {
+	case events2.DeadlineScheduled:
+		d.State = DeadlineScheduled
+	case events2.DeadlineCanceled:
+		d.State = DeadlineCanceled
+	case events2.DeadlineFired:
+		d.State = DeadlineFired
+	}
+	return nil
+}
+
+// DecodeCommand returns the command of a scheduled deadline
+func DecodeCommand(data events2.DeadlineScheduledData) (eh.Command, error) {
+	cmd, err := eh.CreateCommand(data.CommandType)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data.Command, cmd); err != nil {
+		return nil, fmt.Errorf("could not decode the command of the deadline: %w", err)
+	}
+	return cmd, nil
+}
@@ -0,0 +1,125 @@
+package deadline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	events2 "github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeadlineAggregate_HandleCommand(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2017, time.July, 10, 23, 0, 0, 0, time.UTC)
+	}
+
+	id := uuid.New()
+	consentID := uuid.New()
+	at := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	inState := func(state DeadlineState) *DeadlineAggregate {
+		return &DeadlineAggregate{AggregateBase: events.NewAggregateBase(DeadlineAggregateType, id), State: state}
+	}
+
+	cases := map[string]struct {
+		agg            *DeadlineAggregate
+		cmd            eh.Command
+		expectedEvents []eh.Event
+		expectedError  error
+	}{
+		"schedule": {
+			inState(DeadlineNew),
+			&ScheduleDeadline{ID: id, Deadline: at, Command: &consent.MarkAsCompleted{ID: consentID}},
+			[]eh.Event{eh.NewEventForAggregate(events2.DeadlineScheduled, events2.DeadlineScheduledData{
+				Deadline:    at,
+				CommandType: consent.MarkAsCompletedCmdType,
+				Command:     json.RawMessage(`{"ID":"` + consentID.String() + `"}`),
+			}, TimeNow(), DeadlineAggregateType, id, 1)},
+			nil,
+		},
+		"schedule twice": {
+			inState(DeadlineScheduled),
+			&ScheduleDeadline{ID: id, Deadline: at, Command: &consent.MarkAsCompleted{ID: consentID}},
+			nil,
+			domain.InvalidTransitionError{State: "scheduled", Command: "deadline:schedule"},
+		},
+		"schedule again when fired": {
+			inState(DeadlineFired),
+			&ScheduleDeadline{ID: id, Deadline: at, Command: &consent.MarkAsCompleted{ID: consentID}},
+			[]eh.Event{eh.NewEventForAggregate(events2.DeadlineScheduled, events2.DeadlineScheduledData{
+				Deadline:    at,
+				CommandType: consent.MarkAsCompletedCmdType,
+				Command:     json.RawMessage(`{"ID":"` + consentID.String() + `"}`),
+			}, TimeNow(), DeadlineAggregateType, id, 1)},
+			nil,
+		},
+		"fire": {
+			inState(DeadlineScheduled),
+			&FireDeadline{ID: id},
+			[]eh.Event{eh.NewEventForAggregate(events2.DeadlineFired, nil, TimeNow(), DeadlineAggregateType, id, 1)},
+			nil,
+		},
+		"fire when canceled": {
+			inState(DeadlineCanceled),
+			&FireDeadline{ID: id},
+			nil,
+			domain.ErrInvalidTransition,
+		},
+		"cancel": {
+			inState(DeadlineScheduled),
+			&CancelDeadline{ID: id},
+			[]eh.Event{eh.NewEventForAggregate(events2.DeadlineCanceled, nil, TimeNow(), DeadlineAggregateType, id, 1)},
+			nil,
+		},
+		"cancel when not scheduled": {
+			inState(DeadlineNew),
+			&CancelDeadline{ID: id},
+			nil,
+			nil,
+		},
+		"cancel when fired": {
+			inState(DeadlineFired),
+			&CancelDeadline{ID: id},
+			nil,
+			nil,
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := testcase.agg.HandleCommand(context.Background(), testcase.cmd)
+			if !errors.Is(err, testcase.expectedError) {
+				t.Errorf("incorrect error result")
+				t.Log("exp error: ", testcase.expectedError)
+				t.Log("got error: ", err)
+			}
+
+			events := testcase.agg.Events()
+			if !reflect.DeepEqual(events, testcase.expectedEvents) {
+				t.Errorf("test case '%s': incorrect events", name)
+				t.Logf("exp: %#v\n", testcase.expectedEvents)
+				t.Logf("got: %#v\n", events)
+			}
+		})
+	}
+}
+
+func TestDecodeCommand(t *testing.T) {
+	cmd := &consent.MarkAsErrored{ID: uuid.New(), Reason: "timeout", Code: domain.ErrorCodeNegotiationTimeout, Origin: "test"}
+	raw, _ := json.Marshal(cmd)
+
+	decoded, err := DecodeCommand(events2.DeadlineScheduledData{CommandType: cmd.CommandType(), Command: raw})
+	if err != nil || !reflect.DeepEqual(decoded, cmd) {
+		t.Errorf("expected %#v, got %#v, %v", cmd, decoded, err)
+	}
+
+	if _, err := DecodeCommand(events2.DeadlineScheduledData{CommandType: "unknown", Command: raw}); err == nil {
+		t.Error("expected an error for an unknown command type")
+	}
+}
@@ -0,0 +1,31 @@
+package deadline
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const FireDeadlineCmdType = eh.CommandType("deadline:fire")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &FireDeadline{}
+	})
+}
+
+// FireDeadline records that the deadline has passed, it fails when the deadline has been canceled
+type FireDeadline struct {
+	ID uuid.UUID
+}
+
+func (cmd FireDeadline) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd FireDeadline) AggregateType() eh.AggregateType {
+	return DeadlineAggregateType
+}
+
+func (cmd FireDeadline) CommandType() eh.CommandType {
+	return FireDeadlineCmdType
+}
@@ -0,0 +1,38 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"time"
+)
+
+// Retry schedules the next attempt of a failed command as a deadline, so the attempts survive a restart and the
+// command handler never has to wait for them. The time before the second attempt is Delay, it doubles with every
+// next attempt.
+type Retry struct {
+	// Attempts is the number of tries, including the first
+	Attempts int
+	// Delay is the time between the first and the second try
+	Delay time.Duration
+}
+
+// Schedule schedules cmd as the attempt after the given attempt under the deadline id, through commandHandler.
+// It returns false when the given attempt was the last one. A deadline which has already been scheduled by a
+// previous try of the same attempt counts as scheduled.
+func (r Retry) Schedule(ctx context.Context, commandHandler eh.CommandHandler, id uuid.UUID, attempt int, cmd eh.Command) (bool, error) {
+	if attempt >= r.Attempts {
+		return false, nil
+	}
+	err := commandHandler.HandleCommand(ctx, &ScheduleDeadline{
+		ID:       id,
+		Deadline: TimeNow().Add(r.Delay << uint(attempt-1)),
+		Command:  cmd,
+	})
+	if errors.Is(err, domain.ErrInvalidTransition) {
+		return true, nil
+	}
+	return true, err
+}
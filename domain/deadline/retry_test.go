@@ -0,0 +1,79 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetry_Schedule(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	TimeNow = func() time.Time {
+		return now
+	}
+	defer func() {
+		TimeNow = time.Now
+	}()
+
+	id := uuid.New()
+	cmd := &consent.MarkAsCompleted{ID: uuid.New()}
+	retry := Retry{Attempts: 4, Delay: time.Minute}
+
+	cases := map[string]struct {
+		attempt           int
+		scheduleErr       error
+		expectedScheduled bool
+		expectedCommands  []eh.Command
+		expectedErr       bool
+	}{
+		"after the first attempt": {
+			1, nil,
+			true, []eh.Command{&ScheduleDeadline{ID: id, Deadline: now.Add(time.Minute), Command: cmd}}, false,
+		},
+		"after the third attempt": {
+			3, nil,
+			true, []eh.Command{&ScheduleDeadline{ID: id, Deadline: now.Add(4 * time.Minute), Command: cmd}}, false,
+		},
+		"after the last attempt": {
+			4, nil,
+			false, nil, false,
+		},
+		"already scheduled": {
+			1, domain.InvalidTransitionError{State: string(DeadlineScheduled), Command: string(ScheduleDeadlineCmdType)},
+			true, []eh.Command{&ScheduleDeadline{ID: id, Deadline: now.Add(time.Minute), Command: cmd}}, false,
+		},
+		"not scheduled": {
+			1, errors.New("version conflict"),
+			true, []eh.Command{&ScheduleDeadline{ID: id, Deadline: now.Add(time.Minute), Command: cmd}}, true,
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			var commands []eh.Command
+			handler := eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+				commands = append(commands, cmd)
+				return testcase.scheduleErr
+			})
+
+			scheduled, err := retry.Schedule(context.Background(), handler, id, testcase.attempt, cmd)
+			if (err != nil) != testcase.expectedErr {
+				t.Errorf("unexpected error result: %v", err)
+			}
+			if scheduled != testcase.expectedScheduled {
+				t.Errorf("expected scheduled to be %v, got %v", testcase.expectedScheduled, scheduled)
+			}
+			if !reflect.DeepEqual(commands, testcase.expectedCommands) {
+				t.Errorf("incorrect commands")
+				t.Logf("exp: %#v", testcase.expectedCommands)
+				t.Logf("got: %#v", commands)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package deadline
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"time"
+)
+
+const ScheduleDeadlineCmdType = eh.CommandType("deadline:schedule")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &ScheduleDeadline{}
+	})
+}
+
+// ScheduleDeadline schedules Command to be dispatched when Deadline has passed
+type ScheduleDeadline struct {
+	ID       uuid.UUID
+	Deadline time.Time
+	Command  eh.Command
+}
+
+func (cmd ScheduleDeadline) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd ScheduleDeadline) AggregateType() eh.AggregateType {
+	return DeadlineAggregateType
+}
+
+func (cmd ScheduleDeadline) CommandType() eh.CommandType {
+	return ScheduleDeadlineCmdType
+}
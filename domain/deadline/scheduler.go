@@ -0,0 +1,174 @@
+package deadline
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	events2 "github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"log"
+	"sync"
+	"time"
+)
+
+// Pending is a scheduled deadline which has not been canceled or fired yet
+type Pending struct {
+	ID       uuid.UUID
+	Deadline time.Time
+	Data     events2.DeadlineScheduledData
+	// Dispatched is set when the deadline has been fired, until the DeadlineFired event removes it
+	Dispatched bool
+}
+
+func (p Pending) EntityID() uuid.UUID {
+	return p.ID
+}
+
+var _ = eh.Entity(&Pending{})
+
+// Scheduler fires the deadlines: when a deadline passes, it dispatches the scheduled command and then FireDeadline.
+// A deadline of which either command fails is fired again on the next tick.
+// Its state is derived from the deadline events, so it survives a restart by rebuilding it from the event store.
+type Scheduler struct {
+	repo eh.ReadWriteRepo
+	// mu makes loading and storing a deadline atomic
+	mu sync.Mutex
+}
+
+var _ = eh.EventHandler(&Scheduler{})
+
+// NewScheduler creates a Scheduler which stores the pending deadlines in repo
+func NewScheduler(repo eh.ReadWriteRepo) *Scheduler {
+	return &Scheduler{repo: repo}
+}
+
+// MatchEvents returns the events the scheduler must receive
+func (s *Scheduler) MatchEvents() eh.EventMatcher {
+	return eh.MatchAnyEventOf(events2.DeadlineScheduled, events2.DeadlineCanceled, events2.DeadlineFired)
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface
+func (s *Scheduler) HandlerType() eh.EventHandlerType {
+	return eh.EventHandlerType("deadline-scheduler")
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface
+func (s *Scheduler) HandleEvent(ctx context.Context, event eh.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apply(ctx, event)
+}
+
+func (s *Scheduler) apply(ctx context.Context, event eh.Event) error {
+	switch event.EventType() {
+	case events2.DeadlineScheduled:
+		data, ok := event.Data().(events2.DeadlineScheduledData)
+		if !ok {
+			return nil
+		}
+		return s.repo.Save(ctx, &Pending{ID: event.AggregateID(), Deadline: data.Deadline, Data: data})
+	case events2.DeadlineCanceled, events2.DeadlineFired:
+		err := s.repo.Remove(ctx, event.AggregateID())
+		if domain.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Due returns every pending deadline which has passed and has not been fired yet
+func (s *Scheduler) Due(ctx context.Context, now time.Time) ([]Pending, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entities, err := s.repo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var due []Pending
+	for _, entity := range entities {
+		pending := entity.(*Pending)
+		if pending.Dispatched || now.Before(pending.Deadline) {
+			continue
+		}
+		due = append(due, *pending)
+	}
+	return due, nil
+}
+
+// Fire dispatches the command of the deadline and then records that the deadline fired, it is marked as dispatched
+// when both succeeded. A command which the aggregate rejects because it moved on, e.g. a negotiation timeout of a
+// consent which completed in the meantime, counts as dispatched. A deadline which was canceled while its command was
+// dispatched is not recorded as fired.
+func (s *Scheduler) Fire(ctx context.Context, commandHandler eh.CommandHandler, pending Pending) error {
+	cmd, err := DecodeCommand(pending.Data)
+	if err != nil {
+		// firing it again will not help
+		if markErr := s.markDispatched(ctx, pending.ID); markErr != nil {
+			return markErr
+		}
+		return err
+	}
+	err = commandHandler.HandleCommand(ctx, cmd)
+	if errors.Is(err, domain.ErrInvalidTransition) {
+		log.Printf("[DeadlineScheduler] command of deadline %s rejected: %v\n", pending.ID, err)
+	} else if err != nil {
+		return err
+	}
+	err = commandHandler.HandleCommand(ctx, &FireDeadline{ID: pending.ID})
+	if errors.Is(err, domain.ErrInvalidTransition) {
+		log.Printf("[DeadlineScheduler] deadline %s was canceled while it fired\n", pending.ID)
+	} else if err != nil {
+		return err
+	}
+	return s.markDispatched(ctx, pending.ID)
+}
+
+// markDispatched marks the pending deadline as dispatched, unless it was removed in the meantime
+func (s *Scheduler) markDispatched(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entity, err := s.repo.Find(ctx, id)
+	if domain.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pending := entity.(*Pending)
+	pending.Dispatched = true
+	return s.repo.Save(ctx, pending)
+}
+
+// Watch periodically fires the deadlines which have passed, until ctx is done
+func (s *Scheduler) Watch(ctx context.Context, commandHandler eh.CommandHandler, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			due, err := s.Due(ctx, now)
+			if err != nil {
+				log.Printf("[DeadlineScheduler] could not load the deadlines: %v\n", err)
+			}
+			for _, pending := range due {
+				if err := s.Fire(ctx, commandHandler, pending); err != nil {
+					log.Printf("[DeadlineScheduler] could not fire deadline %s (%s): %v\n", pending.ID, pending.Data.CommandType, err)
+				}
+			}
+		}
+	}
+}
+
+// Rebuild clears the state and replays the given events into it
+func (s *Scheduler) Rebuild(ctx context.Context, history []eh.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return domain.Rebuild(ctx, s.repo, history, s.apply)
+}
@@ -0,0 +1,136 @@
+package deadline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	events2 "github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// commandRecorder records the commands, it fails the given number of commands for the aggregates in failing
+type commandRecorder struct {
+	failing  map[uuid.UUID]int
+	commands []eh.Command
+}
+
+func (c *commandRecorder) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	if c.failing[cmd.AggregateID()] > 0 {
+		c.failing[cmd.AggregateID()]--
+		return errors.New("version conflict")
+	}
+	c.commands = append(c.commands, cmd)
+	return nil
+}
+
+func scheduledEvent(id uuid.UUID, at time.Time, cmd eh.Command) eh.Event {
+	raw, _ := json.Marshal(cmd)
+	data := events2.DeadlineScheduledData{Deadline: at, CommandType: cmd.CommandType(), Command: raw}
+	return eh.NewEventForAggregate(events2.DeadlineScheduled, data, at, DeadlineAggregateType, id, 1)
+}
+
+func TestScheduler_Fire(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	due, canceled, later, fired := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	consentID := uuid.New()
+
+	scheduler := NewScheduler(memory.NewRepo())
+	history := []eh.Event{
+		scheduledEvent(due, at, &consent.MarkAsCompleted{ID: consentID}),
+		scheduledEvent(canceled, at, &consent.MarkAsCompleted{ID: uuid.New()}),
+		eh.NewEventForAggregate(events2.DeadlineCanceled, nil, at, DeadlineAggregateType, canceled, 2),
+		scheduledEvent(later, at.Add(time.Hour), &consent.MarkAsCompleted{ID: uuid.New()}),
+		scheduledEvent(fired, at, &consent.MarkAsCompleted{ID: uuid.New()}),
+		eh.NewEventForAggregate(events2.DeadlineFired, nil, at, DeadlineAggregateType, fired, 2),
+	}
+	// the state is rebuilt from the events, as after a restart
+	if err := scheduler.Rebuild(ctx, history); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := scheduler.Due(ctx, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != due {
+		t.Fatalf("expected deadline %s to be due, got %+v", due, pending)
+	}
+
+	// the command fails on the first tick, the deadline is fired again on the next
+	recorder := &commandRecorder{failing: map[uuid.UUID]int{consentID: 1}}
+	if err := scheduler.Fire(ctx, recorder, pending[0]); err == nil {
+		t.Error("expected the failing command to fail the deadline")
+	}
+	pending, _ = scheduler.Due(ctx, at.Add(time.Second))
+	if len(pending) != 1 {
+		t.Fatalf("expected the failed deadline to be due again, got %+v", pending)
+	}
+	if err := scheduler.Fire(ctx, recorder, pending[0]); err != nil {
+		t.Fatal(err)
+	}
+	expected := []eh.Command{&consent.MarkAsCompleted{ID: consentID}, &FireDeadline{ID: due}}
+	if !reflect.DeepEqual(recorder.commands, expected) {
+		t.Errorf("incorrect commands")
+		t.Logf("exp: %#v", expected)
+		t.Logf("got: %#v", recorder.commands)
+	}
+
+	// every deadline is dispatched only once
+	if pending, _ := scheduler.Due(ctx, at.Add(time.Minute)); len(pending) != 0 {
+		t.Errorf("expected no due deadlines, got %+v", pending)
+	}
+}
+
+func TestScheduler_Fire_Rejected(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	id := uuid.New()
+	scheduler := NewScheduler(memory.NewRepo())
+	if err := scheduler.HandleEvent(ctx, scheduledEvent(id, at, &consent.MarkAsCompleted{ID: uuid.New()})); err != nil {
+		t.Fatal(err)
+	}
+
+	// the aggregate moved on and the deadline was canceled while it fired
+	rejecting := eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+		return domain.InvalidTransitionError{State: "completed", Command: string(cmd.CommandType())}
+	})
+	pending, _ := scheduler.Due(ctx, at)
+	if len(pending) != 1 {
+		t.Fatalf("expected a due deadline, got %+v", pending)
+	}
+	if err := scheduler.Fire(ctx, rejecting, pending[0]); err != nil {
+		t.Errorf("expected a rejected command to count as dispatched, got: %v", err)
+	}
+	if pending, _ := scheduler.Due(ctx, at.Add(time.Minute)); len(pending) != 0 {
+		t.Errorf("expected no due deadlines, got %+v", pending)
+	}
+}
+
+func TestScheduler_HandleEvent(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	id := uuid.New()
+	scheduler := NewScheduler(memory.NewRepo())
+
+	if err := scheduler.HandleEvent(ctx, scheduledEvent(id, at, &consent.MarkAsCompleted{ID: uuid.New()})); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.HandleEvent(ctx, eh.NewEventForAggregate(events2.DeadlineCanceled, nil, at, DeadlineAggregateType, id, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ := scheduler.Due(ctx, at); len(pending) != 0 {
+		t.Errorf("expected a canceled deadline not to be due, got %+v", pending)
+	}
+	// a redelivered cancellation is ignored
+	if err := scheduler.HandleEvent(ctx, eh.NewEventForAggregate(events2.DeadlineCanceled, nil, at, DeadlineAggregateType, id, 2)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
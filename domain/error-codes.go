@@ -0,0 +1,70 @@
+package domain
+
+// ErrorCode classifies why a consent was errored or canceled, so clients can react without parsing the reason
+type ErrorCode string
+
+// ErrorCodeDuplicate is used when the consent overlaps with an existing consent for the same parties
+const ErrorCodeDuplicate = ErrorCode("DUPLICATE")
+
+// ErrorCodeUnknownCustodian is used when the custodian is not a valid or known party
+const ErrorCodeUnknownCustodian = ErrorCode("UNKNOWN_CUSTODIAN")
+
+// ErrorCodeUnknownActor is used when the actor is not a valid or known party
+const ErrorCodeUnknownActor = ErrorCode("UNKNOWN_ACTOR")
+
+// ErrorCodeInvalidSubject is used when the subject identifier is not valid
+const ErrorCodeInvalidSubject = ErrorCode("INVALID_SUBJECT")
+
+// ErrorCodeUnknownClass is used when the class of the consent is not in the code list
+const ErrorCodeUnknownClass = ErrorCode("UNKNOWN_CLASS")
+
+// ErrorCodeUnknownPurposeOfUse is used when the purpose of use of the consent is not in the code list
+const ErrorCodeUnknownPurposeOfUse = ErrorCode("UNKNOWN_PURPOSE_OF_USE")
+
+// ErrorCodeCheckTimeout is used when the checks on a proposed consent did not pass in time
+const ErrorCodeCheckTimeout = ErrorCode("CHECK_TIMEOUT")
+
+// ErrorCodeNegotiationFailed is used when the negotiation of the contract could not be started or completed
+const ErrorCodeNegotiationFailed = ErrorCode("NEGOTIATION_FAILED")
+
+// ErrorCodeNegotiationTimeout is used when the parties did not sign the contract in time
+const ErrorCodeNegotiationTimeout = ErrorCode("NEGOTIATION_TIMEOUT")
+
+// ErrorCodeContractRejected is used when a vendor rejected the contract on behalf of a party
+const ErrorCodeContractRejected = ErrorCode("CONTRACT_REJECTED")
+
+// ErrorCodeCanceledByClient is used when a client of the API canceled the consent
+const ErrorCodeCanceledByClient = ErrorCode("CANCELED_BY_CLIENT")
+
+// ErrorCodeWithdrawn is used when an amendment is rejected because the consent was withdrawn while it was amended
+const ErrorCodeWithdrawn = ErrorCode("WITHDRAWN")
+
+// ErrorCodeInternal is used for failures of the service itself, like an unavailable repository
+const ErrorCodeInternal = ErrorCode("INTERNAL")
+
+// ErrorCodes is the catalogue of all error codes
+var ErrorCodes = []ErrorCode{
+	ErrorCodeDuplicate,
+	ErrorCodeUnknownCustodian,
+	ErrorCodeUnknownActor,
+	ErrorCodeInvalidSubject,
+	ErrorCodeUnknownClass,
+	ErrorCodeUnknownPurposeOfUse,
+	ErrorCodeCheckTimeout,
+	ErrorCodeNegotiationFailed,
+	ErrorCodeNegotiationTimeout,
+	ErrorCodeContractRejected,
+	ErrorCodeCanceledByClient,
+	ErrorCodeWithdrawn,
+	ErrorCodeInternal,
+}
+
+// Valid returns true when the code is part of the catalogue
+func (c ErrorCode) Valid() bool {
+	for _, code := range ErrorCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package domain
+
+import "testing"
+
+func TestErrorCode_Valid(t *testing.T) {
+	cases := map[string]struct {
+		code     ErrorCode
+		expected bool
+	}{
+		"duplicate": {ErrorCodeDuplicate, true},
+		"internal":  {ErrorCodeInternal, true},
+		"unknown":   {ErrorCode("SOMETHING_ELSE"), false},
+		"empty":     {ErrorCode(""), false},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			if valid := testcase.code.Valid(); valid != testcase.expected {
+				t.Errorf("expected %v for '%s', got %v", testcase.expected, testcase.code, valid)
+			}
+		})
+	}
+}
@@ -1,6 +1,31 @@
 package domain
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrAggregateCancelled = errors.New("aggregate cancelled")
 var ErrUnknownCommand = errors.New("unknown command")
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+// ErrInvalidAmendment is returned when an amendment does not change the consent or results in invalid terms
+var ErrInvalidAmendment = errors.New("invalid amendment")
+
+// ErrUnknownErrorCode is returned when a consent is errored or canceled with a code which is not in the catalogue
+var ErrUnknownErrorCode = errors.New("unknown error code")
+
+// InvalidTransitionError is returned when an aggregate receives a command which is not allowed in its current state.
+type InvalidTransitionError struct {
+	State   string
+	Command string
+}
+
+func (e InvalidTransitionError) Error() string {
+	return fmt.Sprintf("%s: command %s not allowed in state '%s'", ErrInvalidTransition, e.Command, e.State)
+}
+
+// Unwrap makes errors.Is(err, ErrInvalidTransition) work for every InvalidTransitionError
+func (e InvalidTransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
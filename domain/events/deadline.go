@@ -0,0 +1,25 @@
+package events
+
+import (
+	"encoding/json"
+	eh "github.com/looplab/eventhorizon"
+	"time"
+)
+
+const DeadlineScheduled = eh.EventType("deadline:scheduled")
+const DeadlineCanceled = eh.EventType("deadline:canceled")
+const DeadlineFired = eh.EventType("deadline:fired")
+
+// DeadlineScheduledData is stored when a command is scheduled to be dispatched at a deadline
+type DeadlineScheduledData struct {
+	Deadline time.Time
+	// CommandType and Command are the type and JSON encoding of the command dispatched when the deadline passes
+	CommandType eh.CommandType
+	Command     json.RawMessage
+}
+
+func init() {
+	eh.RegisterEventData(DeadlineScheduled, func() eh.EventData {
+		return &DeadlineScheduledData{}
+	})
+}
@@ -3,6 +3,7 @@ package events
 import (
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
 	"time"
 )
 
@@ -10,20 +11,90 @@ const Proposed = eh.EventType("consent:proposed")
 const Canceled = eh.EventType("consent:canceled")
 const Errored = eh.EventType("consent:errored")
 const Unique = eh.EventType("consent:unique")
+const CustodianChecked = eh.EventType("consent:custodian-checked")
 const SyncStarted = eh.EventType("consent:sync-started")
+const Completed = eh.EventType("consent:completed")
+
+// Expired is stored when the validity period of a completed consent has ended
+const Expired = eh.EventType("consent:expired")
+
+// Withdrawn is stored when a completed consent has been withdrawn, e.g. by the subject
+const Withdrawn = eh.EventType("consent:withdrawn")
+
+// Amended is stored when the terms of a completed consent are changed, it starts the checks and negotiation of the new version
+const Amended = eh.EventType("consent:amended")
+
+// AmendmentRejected is stored when the checks or negotiation of an amendment failed, the previous version stays in force
+const AmendmentRejected = eh.EventType("consent:amendment-rejected")
 
 type ProposedData struct {
 	ID          uuid.UUID
 	CustodianID string
 	SubjectID   string
 	ActorID     string
-	Start       time.Time
+	// Class is the category of data the consent covers
+	Class string
+	// PurposeOfUse is the HL7 PurposeOfUse code of the reason the data is used for
+	PurposeOfUse string
+	Start        time.Time
+	End          time.Time
 }
 
 type SyncStartedData struct {
 	SyncID uuid.UUID
 }
 
+// ErroredData contains why a consent could not be completed
+type ErroredData struct {
+	Reason string
+	// Code classifies the reason
+	Code domain.ErrorCode
+	// Origin is the saga or other component which marked the consent as errored
+	Origin string
+}
+
+// CanceledData contains why a consent was canceled
+type CanceledData struct {
+	Reason string
+	// Code classifies the reason
+	Code domain.ErrorCode
+	// Origin is the saga or other component which canceled the consent
+	Origin string
+}
+
+// WithdrawnData contains who withdrew a completed consent and why, the time is the timestamp of the event
+type WithdrawnData struct {
+	WithdrawnBy string
+	Reason      string
+}
+
+// AmendedData contains the terms of the new version of an amended consent
+type AmendedData struct {
+	ProposedData
+	// Version is the number of the new version, the proposed consent is version 1
+	Version int
+}
+
+// AmendmentRejectedData contains why an amendment was rejected
+type AmendmentRejectedData struct {
+	Reason string
+	// Code classifies the reason
+	Code domain.ErrorCode
+	// Origin is the saga or other component which rejected the amendment
+	Origin string
+}
+
+// Terms returns the terms of the consent for events which propose or amend one
+func Terms(event eh.Event) (ProposedData, bool) {
+	switch data := event.Data().(type) {
+	case ProposedData:
+		return data, event.EventType() == Proposed
+	case AmendedData:
+		return data.ProposedData, event.EventType() == Amended
+	}
+	return ProposedData{}, false
+}
+
 func init() {
 	eh.RegisterEventData(Proposed, func() eh.EventData {
 		return &ProposedData{}
@@ -32,6 +103,24 @@ func init() {
 	eh.RegisterEventData(SyncStarted, func() eh.EventData {
 		return &SyncStartedData{}
 	})
-}
 
+	eh.RegisterEventData(Errored, func() eh.EventData {
+		return &ErroredData{}
+	})
+
+	eh.RegisterEventData(Canceled, func() eh.EventData {
+		return &CanceledData{}
+	})
 
+	eh.RegisterEventData(Withdrawn, func() eh.EventData {
+		return &WithdrawnData{}
+	})
+
+	eh.RegisterEventData(Amended, func() eh.EventData {
+		return &AmendedData{}
+	})
+
+	eh.RegisterEventData(AmendmentRejected, func() eh.EventData {
+		return &AmendmentRejectedData{}
+	})
+}
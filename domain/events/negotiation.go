@@ -0,0 +1,77 @@
+package events
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+)
+
+const NegotiationStarted = eh.EventType("negotiation:started")
+const VendorResponded = eh.EventType("negotiation:vendor-responded")
+const PartyAcknowledged = eh.EventType("negotiation:party-acknowledged")
+const NegotiationCompleted = eh.EventType("negotiation:completed")
+const NegotiationFailed = eh.EventType("negotiation:failed")
+
+// NegotiationParty is a party which must sign the contract through all of its vendors
+type NegotiationParty struct {
+	ID        string
+	Role      string
+	VendorIDs []string
+	// Endpoints contains the URL of the consent endpoint per vendor, the contract is sent to these
+	Endpoints map[string]string
+}
+
+// NegotiationStartedData is stored when the negotiation of the contract of a consent has started
+type NegotiationStartedData struct {
+	ConsentID uuid.UUID
+	Contents  string
+	Parties   []NegotiationParty
+}
+
+// VendorRespondedData is stored when a vendor signed or rejected the contract on behalf of a party
+type VendorRespondedData struct {
+	PartyID  string
+	VendorID string
+	Signed   bool
+	Reason   string
+}
+
+// PartyAcknowledgedData is published when a party accepted the contract of a consent
+type PartyAcknowledgedData struct {
+	ConsentID uuid.UUID
+	PartyID   string
+}
+
+// NegotiationCompletedData is published when all parties accepted the contract of a consent
+type NegotiationCompletedData struct {
+	ConsentID uuid.UUID
+}
+
+// NegotiationFailedData is published when the contract of a consent could not be agreed upon
+type NegotiationFailedData struct {
+	ConsentID uuid.UUID
+	Reason    string
+	Code      domain.ErrorCode
+}
+
+func init() {
+	eh.RegisterEventData(NegotiationStarted, func() eh.EventData {
+		return &NegotiationStartedData{}
+	})
+
+	eh.RegisterEventData(VendorResponded, func() eh.EventData {
+		return &VendorRespondedData{}
+	})
+
+	eh.RegisterEventData(PartyAcknowledged, func() eh.EventData {
+		return &PartyAcknowledgedData{}
+	})
+
+	eh.RegisterEventData(NegotiationCompleted, func() eh.EventData {
+		return &NegotiationCompletedData{}
+	})
+
+	eh.RegisterEventData(NegotiationFailed, func() eh.EventData {
+		return &NegotiationFailedData{}
+	})
+}
@@ -0,0 +1,31 @@
+package negotiation
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const CompleteNegotiationCmdType = eh.CommandType("negotiation:complete")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &CompleteNegotiation{}
+	})
+}
+
+// CompleteNegotiation completes a negotiation in which every party has signed the contract
+type CompleteNegotiation struct {
+	ID uuid.UUID
+}
+
+func (cmd CompleteNegotiation) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd CompleteNegotiation) AggregateType() eh.AggregateType {
+	return ConsentNegotiationAggregateType
+}
+
+func (cmd CompleteNegotiation) CommandType() eh.CommandType {
+	return CompleteNegotiationCmdType
+}
@@ -2,10 +2,16 @@ package negotiation
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	events2 "github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"log"
+	"strings"
+	"time"
 )
 
 const ConsentNegotiationAggregateType = eh.AggregateType("consent-negotiation")
@@ -18,11 +24,46 @@ func init() {
 	})
 }
 
+// ErrUnknownVendor is returned when a response is recorded for a vendor which does not represent the party
+var ErrUnknownVendor = errors.New("vendor does not represent the party")
+
+// ErrAlreadyResponded is returned when a vendor responds to the same contract twice
+var ErrAlreadyResponded = errors.New("vendor already responded")
+
+// ErrMissingParty is returned when a negotiation is started without a custodian or actor which can sign the contract
+var ErrMissingParty = errors.New("negotiation requires a custodian and an actor with a vendor")
+
+// ErrNegotiationIncomplete is returned when a negotiation is completed before every party has signed
+var ErrNegotiationIncomplete = errors.New("not every party has signed the contract")
+
+type NegotiationState = domain.State
+
+// NegotiationNew is the state of an aggregate without any events
+const NegotiationNew = NegotiationState("")
+const NegotiationPending = NegotiationState("pending")
+const NegotiationCompleted = NegotiationState("completed")
+const NegotiationFailed = NegotiationState("failed")
+
+// allowedTransitions lists per command the states in which the aggregate accepts it
+var allowedTransitions = domain.Transitions{
+	StartNegotiationCmdType:     {NegotiationNew},
+	RecordVendorResponseCmdType: {NegotiationPending},
+	CompleteNegotiationCmdType:  {NegotiationPending},
+}
+
+var TimeNow = func() time.Time {
+	return time.Now()
+}
+
+// NegotiationAggregate is the negotiation of the contract of a consent with all parties involved.
+// A party signs the contract when all of its vendors have signed, one rejection fails the negotiation.
 type NegotiationAggregate struct {
 	*events.AggregateBase
-	Contents string
+	ConsentID uuid.UUID
+	Contents  string
+	Parties   []Party
 
-	State string
+	State NegotiationState
 }
 
 type PartyRole string
@@ -33,22 +74,167 @@ const SubjectRole = PartyRole("subject")
 
 // Party keeps track of vendor responses representing this party
 type Party struct {
-	ID              string
-	Role            PartyRole
-	Vendor          []string // list of all vendors representing this party
+	ID     string
+	Role   PartyRole
+	Vendor []string // list of all vendors representing this party
+	// Endpoints contains the URL of the consent endpoint per vendor
+	Endpoints       map[string]string
 	VendorResponses []VendorResponse
 }
 
+// Signed returns true when every vendor of the party has signed the contract
+func (p Party) Signed() bool {
+	for _, vendorID := range p.Vendor {
+		response, ok := p.response(vendorID)
+		if !ok || !response.Signed {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Party) response(vendorID string) (VendorResponse, bool) {
+	for _, response := range p.VendorResponses {
+		if response.VendorID == vendorID {
+			return response, true
+		}
+	}
+	return VendorResponse{}, false
+}
+
+// Represents returns true when the vendor represents the party
+func (p Party) Represents(vendorID string) bool {
+	for _, vendor := range p.Vendor {
+		if vendor == vendorID {
+			return true
+		}
+	}
+	return false
+}
+
 type VendorResponse struct {
-	Signed bool
+	VendorID string
+	Signed   bool
+	// Reason is given by the vendor when it rejected the contract
+	Reason string
 }
 
-func (n NegotiationAggregate) HandleCommand(ctx context.Context, command eh.Command) error {
-	fmt.Printf("[NegotiationAggregate] command: %+v\n", command)
+func (n *NegotiationAggregate) HandleCommand(ctx context.Context, command eh.Command) error {
+	log.Printf("[NegotiationAggregate] command: %v, %+v\n", command.CommandType(), command)
+
+	if err := allowedTransitions.Check(n.State, command.CommandType()); err != nil {
+		return err
+	}
+
+	switch cmd := command.(type) {
+	case *StartNegotiation:
+		for _, role := range []PartyRole{CustodianRole, ActorRole} {
+			if !hasSigner(cmd.Parties, role) {
+				return fmt.Errorf("%w: no %s", ErrMissingParty, role)
+			}
+		}
+		data := events2.NegotiationStartedData{ConsentID: cmd.ConsentID, Contents: cmd.Contents}
+		for _, party := range cmd.Parties {
+			data.Parties = append(data.Parties, events2.NegotiationParty{ID: party.ID, Role: string(party.Role), VendorIDs: party.Vendor, Endpoints: party.Endpoints})
+		}
+		n.StoreEvent(events2.NegotiationStarted, data, TimeNow())
+	case *RecordVendorResponse:
+		party, ok := n.party(cmd.PartyID)
+		if !ok || !party.Represents(cmd.VendorID) {
+			return fmt.Errorf("%w: vendor %s, party %s", ErrUnknownVendor, cmd.VendorID, cmd.PartyID)
+		}
+		if _, ok := party.response(cmd.VendorID); ok {
+			return fmt.Errorf("%w: vendor %s, party %s", ErrAlreadyResponded, cmd.VendorID, cmd.PartyID)
+		}
+		n.StoreEvent(events2.VendorResponded, events2.VendorRespondedData{
+			PartyID:  cmd.PartyID,
+			VendorID: cmd.VendorID,
+			Signed:   cmd.Signed,
+			Reason:   cmd.Reason,
+		}, TimeNow())
+		if !cmd.Signed {
+			n.StoreEvent(events2.NegotiationFailed, events2.NegotiationFailedData{
+				ConsentID: n.ConsentID,
+				Reason:    fmt.Sprintf("vendor %s rejected the contract for party %s: %s", cmd.VendorID, cmd.PartyID, cmd.Reason),
+				Code:      domain.ErrorCodeContractRejected,
+			}, TimeNow())
+			break
+		}
+		party.VendorResponses = append(append([]VendorResponse(nil), party.VendorResponses...), VendorResponse{VendorID: cmd.VendorID, Signed: true})
+		if party.Signed() {
+			n.StoreEvent(events2.PartyAcknowledged, events2.PartyAcknowledgedData{ConsentID: n.ConsentID, PartyID: cmd.PartyID}, TimeNow())
+		}
+	case *CompleteNegotiation:
+		if unsigned := n.unsigned(); len(unsigned) > 0 {
+			return fmt.Errorf("%w, waiting for: %s", ErrNegotiationIncomplete, strings.Join(unsigned, ", "))
+		}
+		n.StoreEvent(events2.NegotiationCompleted, events2.NegotiationCompletedData{ConsentID: n.ConsentID}, TimeNow())
+	default:
+		return domain.ErrUnknownCommand
+	}
 	return nil
 }
 
-func (n NegotiationAggregate) ApplyEvent(ctx context.Context, event eh.Event) error {
-	fmt.Printf("[NegotiationAggregate] event: %+v\n", event)
+// hasSigner returns true when a party with the role has a vendor which can sign the contract
+func hasSigner(parties []Party, role PartyRole) bool {
+	for _, party := range parties {
+		if party.Role == role && len(party.Vendor) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// party returns a copy of the party with the given ID
+func (n *NegotiationAggregate) party(id string) (Party, bool) {
+	for _, party := range n.Parties {
+		if party.ID == id {
+			return party, true
+		}
+	}
+	return Party{}, false
+}
+
+// unsigned returns the IDs of the parties which have not signed the contract yet
+func (n *NegotiationAggregate) unsigned() []string {
+	var unsigned []string
+	for _, party := range n.Parties {
+		if !party.Signed() {
+			unsigned = append(unsigned, party.ID)
+		}
+	}
+	return unsigned
+}
+
+func (n *NegotiationAggregate) ApplyEvent(ctx context.Context, event eh.Event) error {
+	log.Printf("[NegotiationAggregate] event: %+v\n", event)
+	switch event.EventType() {
+	case events2.NegotiationStarted:
+		data, ok := event.Data().(events2.NegotiationStartedData)
+		if !ok {
+			return errors.New("event data of wrong type")
+		}
+		n.ConsentID = data.ConsentID
+		n.Contents = data.Contents
+		n.Parties = nil
+		for _, party := range data.Parties {
+			n.Parties = append(n.Parties, Party{ID: party.ID, Role: PartyRole(party.Role), Vendor: party.VendorIDs, Endpoints: party.Endpoints})
+		}
+		n.State = NegotiationPending
+	case events2.VendorResponded:
+		data, ok := event.Data().(events2.VendorRespondedData)
+		if !ok {
+			return errors.New("event data of wrong type")
+		}
+		for i, party := range n.Parties {
+			if party.ID == data.PartyID {
+				n.Parties[i].VendorResponses = append(party.VendorResponses, VendorResponse{VendorID: data.VendorID, Signed: data.Signed, Reason: data.Reason})
+			}
+		}
+	case events2.NegotiationCompleted:
+		n.State = NegotiationCompleted
+	case events2.NegotiationFailed:
+		n.State = NegotiationFailed
+	}
 	return nil
 }
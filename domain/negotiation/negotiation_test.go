@@ -0,0 +1,171 @@
+package negotiation
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	events2 "github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNegotiationAggregate_HandleCommand(t *testing.T) {
+	TimeNow = func() time.Time {
+		return time.Date(2017, time.July, 10, 23, 0, 0, 0, time.Local)
+	}
+
+	id := uuid.New()
+	consentID := uuid.New()
+	started := func(responses ...VendorResponse) *NegotiationAggregate {
+		return &NegotiationAggregate{
+			AggregateBase: events.NewAggregateBase(ConsentNegotiationAggregateType, id),
+			ConsentID:     consentID,
+			Parties: []Party{
+				{ID: "agb:123", Role: CustodianRole, Vendor: []string{"vendor:1", "vendor:2"}, VendorResponses: responses},
+				{ID: "agb:456", Role: ActorRole, Vendor: []string{"vendor:1"}, VendorResponses: []VendorResponse{{VendorID: "vendor:1", Signed: true}}},
+			},
+			State: NegotiationPending,
+		}
+	}
+
+	cases := map[string]struct {
+		agg            *NegotiationAggregate
+		cmd            eh.Command
+		expectedEvents []eh.Event
+		expectedError  error
+	}{
+		"start": {
+			&NegotiationAggregate{AggregateBase: events.NewAggregateBase(ConsentNegotiationAggregateType, id)},
+			&StartNegotiation{ID: id, ConsentID: consentID, Contents: "contract", Parties: []Party{
+				{ID: "agb:123", Role: CustodianRole, Vendor: []string{"vendor:1"}, Endpoints: map[string]string{"vendor:1": "http://vendor1"}},
+				{ID: "agb:456", Role: ActorRole, Vendor: []string{"vendor:2"}, Endpoints: map[string]string{"vendor:2": "http://vendor2"}},
+			}},
+			[]eh.Event{eh.NewEventForAggregate(events2.NegotiationStarted, events2.NegotiationStartedData{
+				ConsentID: consentID,
+				Contents:  "contract",
+				Parties: []events2.NegotiationParty{
+					{ID: "agb:123", Role: "custodian", VendorIDs: []string{"vendor:1"}, Endpoints: map[string]string{"vendor:1": "http://vendor1"}},
+					{ID: "agb:456", Role: "actor", VendorIDs: []string{"vendor:2"}, Endpoints: map[string]string{"vendor:2": "http://vendor2"}},
+				},
+			}, TimeNow(), ConsentNegotiationAggregateType, id, 1)},
+			nil,
+		},
+		"start without actor": {
+			&NegotiationAggregate{AggregateBase: events.NewAggregateBase(ConsentNegotiationAggregateType, id)},
+			&StartNegotiation{ID: id, ConsentID: consentID, Contents: "contract", Parties: []Party{
+				{ID: "agb:123", Role: CustodianRole, Vendor: []string{"vendor:1"}},
+				{ID: "agb:456", Role: ActorRole},
+			}},
+			nil,
+			ErrMissingParty,
+		},
+		"start twice": {
+			started(),
+			&StartNegotiation{ID: id, ConsentID: consentID, Contents: "contract"},
+			nil,
+			domain.InvalidTransitionError{State: "pending", Command: "negotiation:start"},
+		},
+		"first vendor of a party signs": {
+			started(),
+			&RecordVendorResponse{ID: id, PartyID: "agb:123", VendorID: "vendor:1", Signed: true},
+			[]eh.Event{eh.NewEventForAggregate(events2.VendorResponded, events2.VendorRespondedData{PartyID: "agb:123", VendorID: "vendor:1", Signed: true}, TimeNow(), ConsentNegotiationAggregateType, id, 1)},
+			nil,
+		},
+		"last vendor of a party signs": {
+			started(VendorResponse{VendorID: "vendor:1", Signed: true}),
+			&RecordVendorResponse{ID: id, PartyID: "agb:123", VendorID: "vendor:2", Signed: true},
+			[]eh.Event{
+				eh.NewEventForAggregate(events2.VendorResponded, events2.VendorRespondedData{PartyID: "agb:123", VendorID: "vendor:2", Signed: true}, TimeNow(), ConsentNegotiationAggregateType, id, 1),
+				eh.NewEventForAggregate(events2.PartyAcknowledged, events2.PartyAcknowledgedData{ConsentID: consentID, PartyID: "agb:123"}, TimeNow(), ConsentNegotiationAggregateType, id, 2),
+			},
+			nil,
+		},
+		"vendor rejects": {
+			started(),
+			&RecordVendorResponse{ID: id, PartyID: "agb:123", VendorID: "vendor:2", Reason: "no"},
+			[]eh.Event{
+				eh.NewEventForAggregate(events2.VendorResponded, events2.VendorRespondedData{PartyID: "agb:123", VendorID: "vendor:2", Reason: "no"}, TimeNow(), ConsentNegotiationAggregateType, id, 1),
+				eh.NewEventForAggregate(events2.NegotiationFailed, events2.NegotiationFailedData{ConsentID: consentID, Reason: "vendor vendor:2 rejected the contract for party agb:123: no", Code: domain.ErrorCodeContractRejected}, TimeNow(), ConsentNegotiationAggregateType, id, 2),
+			},
+			nil,
+		},
+		"unknown vendor": {
+			started(),
+			&RecordVendorResponse{ID: id, PartyID: "agb:456", VendorID: "vendor:2", Signed: true},
+			nil,
+			ErrUnknownVendor,
+		},
+		"vendor responds twice": {
+			started(),
+			&RecordVendorResponse{ID: id, PartyID: "agb:456", VendorID: "vendor:1", Signed: true},
+			nil,
+			ErrAlreadyResponded,
+		},
+		"complete when all signed": {
+			started(VendorResponse{VendorID: "vendor:1", Signed: true}, VendorResponse{VendorID: "vendor:2", Signed: true}),
+			&CompleteNegotiation{ID: id},
+			[]eh.Event{eh.NewEventForAggregate(events2.NegotiationCompleted, events2.NegotiationCompletedData{ConsentID: consentID}, TimeNow(), ConsentNegotiationAggregateType, id, 1)},
+			nil,
+		},
+		"complete before all signed": {
+			started(VendorResponse{VendorID: "vendor:1", Signed: true}),
+			&CompleteNegotiation{ID: id},
+			nil,
+			ErrNegotiationIncomplete,
+		},
+		"record response when failed": {
+			&NegotiationAggregate{AggregateBase: events.NewAggregateBase(ConsentNegotiationAggregateType, id), State: NegotiationFailed},
+			&RecordVendorResponse{ID: id, PartyID: "agb:123", VendorID: "vendor:1", Signed: true},
+			nil,
+			domain.ErrInvalidTransition,
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := testcase.agg.HandleCommand(context.Background(), testcase.cmd)
+			if !errors.Is(err, testcase.expectedError) {
+				t.Errorf("incorrect error result")
+				t.Log("exp error: ", testcase.expectedError)
+				t.Log("got error: ", err)
+			}
+
+			events := testcase.agg.Events()
+			if !reflect.DeepEqual(events, testcase.expectedEvents) {
+				t.Errorf("test case '%s': incorrect events", name)
+				t.Logf("exp: %#v\n", testcase.expectedEvents)
+				t.Logf("got: %#v\n", events)
+			}
+		})
+	}
+}
+
+func TestNegotiationAggregate_ApplyEvent(t *testing.T) {
+	id := uuid.New()
+	consentID := uuid.New()
+	agg := &NegotiationAggregate{AggregateBase: events.NewAggregateBase(ConsentNegotiationAggregateType, id)}
+	history := []eh.Event{
+		eh.NewEventForAggregate(events2.NegotiationStarted, events2.NegotiationStartedData{
+			ConsentID: consentID,
+			Contents:  "contract",
+			Parties:   []events2.NegotiationParty{{ID: "agb:123", Role: "custodian", VendorIDs: []string{"vendor:1"}}},
+		}, TimeNow(), ConsentNegotiationAggregateType, id, 1),
+		eh.NewEventForAggregate(events2.VendorResponded, events2.VendorRespondedData{PartyID: "agb:123", VendorID: "vendor:1", Signed: true}, TimeNow(), ConsentNegotiationAggregateType, id, 2),
+		eh.NewEventForAggregate(events2.PartyAcknowledged, events2.PartyAcknowledgedData{ConsentID: consentID, PartyID: "agb:123"}, TimeNow(), ConsentNegotiationAggregateType, id, 3),
+		eh.NewEventForAggregate(events2.NegotiationCompleted, events2.NegotiationCompletedData{ConsentID: consentID}, TimeNow(), ConsentNegotiationAggregateType, id, 4),
+	}
+	for _, event := range history {
+		if err := agg.ApplyEvent(context.Background(), event); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expectedParties := []Party{{ID: "agb:123", Role: CustodianRole, Vendor: []string{"vendor:1"}, VendorResponses: []VendorResponse{{VendorID: "vendor:1", Signed: true}}}}
+	if agg.State != NegotiationCompleted || agg.ConsentID != consentID || !reflect.DeepEqual(agg.Parties, expectedParties) {
+		t.Errorf("incorrect state after replay: %+v", agg)
+	}
+}
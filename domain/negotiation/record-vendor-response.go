@@ -0,0 +1,35 @@
+package negotiation
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const RecordVendorResponseCmdType = eh.CommandType("negotiation:record-vendor-response")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &RecordVendorResponse{}
+	})
+}
+
+// RecordVendorResponse records that a vendor signed or rejected the contract on behalf of a party
+type RecordVendorResponse struct {
+	ID       uuid.UUID
+	PartyID  string
+	VendorID string
+	Signed   bool   `eh:"optional"`
+	Reason   string `eh:"optional"`
+}
+
+func (cmd RecordVendorResponse) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd RecordVendorResponse) AggregateType() eh.AggregateType {
+	return ConsentNegotiationAggregateType
+}
+
+func (cmd RecordVendorResponse) CommandType() eh.CommandType {
+	return RecordVendorResponseCmdType
+}
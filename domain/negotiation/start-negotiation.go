@@ -0,0 +1,34 @@
+package negotiation
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+)
+
+const StartNegotiationCmdType = eh.CommandType("negotiation:start")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &StartNegotiation{}
+	})
+}
+
+// StartNegotiation starts the negotiation of the contract of a consent. The ID is the sync ID of the consent.
+type StartNegotiation struct {
+	ID        uuid.UUID
+	ConsentID uuid.UUID
+	Contents  string
+	Parties   []Party `eh:"optional"`
+}
+
+func (cmd StartNegotiation) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd StartNegotiation) AggregateType() eh.AggregateType {
+	return ConsentNegotiationAggregateType
+}
+
+func (cmd StartNegotiation) CommandType() eh.CommandType {
+	return StartNegotiationCmdType
+}
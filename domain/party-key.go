@@ -0,0 +1,44 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PartyKey identifies the custodian, subject and actor of a consent.
+// Use NewPartyKey to create one, it normalises the identifiers.
+type PartyKey struct {
+	CustodianID string
+	SubjectID   string
+	ActorID     string
+}
+
+// NewPartyKey returns the PartyKey for the given identifiers, normalised with NormaliseIdentifier
+func NewPartyKey(custodianID, subjectID, actorID string) PartyKey {
+	return PartyKey{
+		CustodianID: NormaliseIdentifier(custodianID),
+		SubjectID:   NormaliseIdentifier(subjectID),
+		ActorID:     NormaliseIdentifier(actorID),
+	}
+}
+
+// String returns an unambiguous representation of the key: every part is prefixed with its length,
+// so no combination of identifiers can produce the representation of another combination.
+func (k PartyKey) String() string {
+	return fmt.Sprintf("%d:%s|%d:%s|%d:%s",
+		len(k.CustodianID), k.CustodianID,
+		len(k.SubjectID), k.SubjectID,
+		len(k.ActorID), k.ActorID)
+}
+
+// NormaliseIdentifier trims surrounding whitespace and lowercases the scheme of an identifier, which is everything
+// up to the last colon (e.g. "URN:OID:2.16.840.1.113883.2.4.6.3:999" becomes "urn:oid:2.16.840.1.113883.2.4.6.3:999").
+// The value after the last colon is kept as is, since it may be case sensitive.
+func NormaliseIdentifier(id string) string {
+	id = strings.TrimSpace(id)
+	i := strings.LastIndex(id, ":")
+	if i < 0 {
+		return id
+	}
+	return strings.ToLower(id[:i]) + id[i:]
+}
@@ -0,0 +1,73 @@
+package domain
+
+import "testing"
+
+func TestPartyKey_String(t *testing.T) {
+	cases := map[string]struct {
+		a, b     PartyKey
+		expected bool
+	}{
+		"shifted boundary between custodian and subject": {
+			NewPartyKey("agb:1", "23bsn:9", "agb:456"),
+			NewPartyKey("agb:12", "3bsn:9", "agb:456"),
+			false,
+		},
+		"shifted boundary between subject and actor": {
+			NewPartyKey("agb:123", "bsn:99", "9agb:456"),
+			NewPartyKey("agb:123", "bsn:999", "agb:456"),
+			false,
+		},
+		"delimiter inside an identifier": {
+			NewPartyKey("agb:1|2", "bsn:9", "agb:4"),
+			NewPartyKey("agb:1", "2|bsn:9", "agb:4"),
+			false,
+		},
+		"length prefix inside an identifier": {
+			NewPartyKey("a", "1:b|1:c", ""),
+			NewPartyKey("a|1:b", "c", ""),
+			false,
+		},
+		"swapped roles": {
+			NewPartyKey("agb:123", "bsn:999", "agb:456"),
+			NewPartyKey("agb:456", "bsn:999", "agb:123"),
+			false,
+		},
+		"whitespace": {
+			NewPartyKey(" agb:123", "bsn:999\t", "agb:456\n"),
+			NewPartyKey("agb:123", "bsn:999", "agb:456"),
+			true,
+		},
+		"urn case": {
+			NewPartyKey("URN:OID:2.16.840.1.113883.2.4.6.1:123", "urn:oid:2.16.840.1.113883.2.4.6.3:999", "Urn:Oid:2.16.840.1.113883.2.4.6.1:456"),
+			NewPartyKey("urn:oid:2.16.840.1.113883.2.4.6.1:123", "URN:oid:2.16.840.1.113883.2.4.6.3:999", "urn:oid:2.16.840.1.113883.2.4.6.1:456"),
+			true,
+		},
+		"value case is kept": {
+			NewPartyKey("agb:abc", "bsn:999", "agb:456"),
+			NewPartyKey("agb:ABC", "bsn:999", "agb:456"),
+			false,
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			if (testcase.a.String() == testcase.b.String()) != testcase.expected {
+				t.Errorf("expected equality of %q and %q to be %v", testcase.a, testcase.b, testcase.expected)
+			}
+		})
+	}
+}
+
+func TestNormaliseIdentifier(t *testing.T) {
+	cases := map[string]string{
+		"agb:123":     "agb:123",
+		" AGB:123 ":   "agb:123",
+		"no-scheme":   "no-scheme",
+		"URN:OID:1:X": "urn:oid:1:X",
+	}
+	for input, expected := range cases {
+		if got := NormaliseIdentifier(input); got != expected {
+			t.Errorf("NormaliseIdentifier(%q): expected %q, got %q", input, expected, got)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"context"
+	eh "github.com/looplab/eventhorizon"
+)
+
+// IsNotFound returns true when err is returned by a repo for an entity which it does not hold
+func IsNotFound(err error) bool {
+	rrErr, ok := err.(eh.RepoError)
+	return ok && rrErr.Err == eh.ErrEntityNotFound
+}
+
+// Rebuild removes all entities from repo and replays the history into it with apply.
+// The components which keep their state in memory use it to rebuild that state from the event store on startup.
+func Rebuild(ctx context.Context, repo eh.ReadWriteRepo, history []eh.Event, apply func(context.Context, eh.Event) error) error {
+	entities, err := repo.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, entity := range entities {
+		if err := repo.Remove(ctx, entity.EntityID()); err != nil {
+			return err
+		}
+	}
+	for _, event := range history {
+		if err := apply(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
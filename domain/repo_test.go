@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"testing"
+	"time"
+)
+
+func TestRebuild(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepo()
+	stale := &mocks.Model{ID: uuid.New()}
+	if err := repo.Save(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	id := uuid.New()
+	history := []eh.Event{eh.NewEventForAggregate(mocks.EventType, nil, time.Now(), mocks.AggregateType, id, 1)}
+	err := Rebuild(ctx, repo, history, func(ctx context.Context, event eh.Event) error {
+		return repo.Save(ctx, &mocks.Model{ID: event.AggregateID()})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Find(ctx, stale.ID); !IsNotFound(err) {
+		t.Errorf("expected the stale entity to be removed, got %v", err)
+	}
+	if _, err := repo.Find(ctx, id); err != nil {
+		t.Errorf("expected the replayed entity, got %v", err)
+	}
+}
@@ -2,17 +2,26 @@ package sagas
 
 import (
 	"context"
+	"fmt"
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/eventhandler/saga"
+	"github.com/nuts-foundation/nuts-consent-service/codelist"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
 	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
 	"github.com/nuts-foundation/nuts-consent-service/domain/events"
-	"github.com/nuts-foundation/nuts-crypto/pkg"
-	"github.com/nuts-foundation/nuts-crypto/pkg/types"
+	"github.com/nuts-foundation/nuts-consent-service/registry"
+	"log"
+	"strings"
 )
 
 const CheckPartiesSagaType = saga.Type("CheckPartiesSagaType")
 
 type CheckPartiesSaga struct {
+	Registry registry.PartyRegistry
+	// Custodians contains the parties this node can act as custodian for, the Registry is used when not set
+	Custodians registry.PartyRegistry
+	// Codes contains the classes and purposes of use a consent may have
+	Codes codelist.List
 }
 
 func (c CheckPartiesSaga) SagaType() saga.Type {
@@ -21,32 +30,78 @@ func (c CheckPartiesSaga) SagaType() saga.Type {
 
 func (c CheckPartiesSaga) RunSaga(ctx context.Context, event eh.Event) []eh.Command {
 	switch event.EventType() {
-	case events.Proposed:
-		data, ok := event.Data().(events.ProposedData)
+	// the parties of an amended consent are checked again, they may have left the registry since
+	case events.Proposed, events.Amended:
+		data, ok := events.Terms(event)
 		if !ok {
 			return []eh.Command{&consent.MarkAsErrored{
 				ID:     event.AggregateID(),
 				Reason: "event did not contain proposedData",
+				Code:   domain.ErrorCodeInternal,
+				Origin: string(CheckPartiesSagaType),
 			}}
 		}
 
-		if c.CheckCustodian(data.CustodianID) {
-			return []eh.Command{&consent.MarkCustodianChecked{
-				ID:     event.AggregateID(),
-			}}
-		} else {
+		// all failures are reported in the reason, the code is the one of the first failure
+		var failures []string
+		var code domain.ErrorCode
+		fail := func(failureCode domain.ErrorCode, failure string) {
+			if code == "" {
+				code = failureCode
+			}
+			failures = append(failures, failure)
+		}
+		if !c.CheckCustodian(data.CustodianID) {
+			fail(domain.ErrorCodeUnknownCustodian, fmt.Sprintf("custodian %s is not a valid or known party", data.CustodianID))
+		}
+		if !c.CheckActor(data.ActorID) {
+			fail(domain.ErrorCodeUnknownActor, fmt.Sprintf("actor %s is not a valid or known party", data.ActorID))
+		}
+		if err := c.CheckSubject(data.SubjectID); err != nil {
+			fail(domain.ErrorCodeInvalidSubject, fmt.Sprintf("subject %s is not valid: %v", data.SubjectID, err))
+		}
+		if err := c.Codes.ValidateClass(data.Class); err != nil {
+			fail(domain.ErrorCodeUnknownClass, err.Error())
+		}
+		if err := c.Codes.ValidatePurposeOfUse(data.PurposeOfUse); err != nil {
+			fail(domain.ErrorCodeUnknownPurposeOfUse, err.Error())
+		}
+
+		if len(failures) > 0 {
 			return []eh.Command{&consent.MarkAsErrored{
 				ID:     event.AggregateID(),
-				Reason: "custodian is not a valid or known party",
+				Reason: strings.Join(failures, "; "),
+				Code:   code,
+				Origin: string(CheckPartiesSagaType),
 			}}
 		}
-
+		return []eh.Command{&consent.MarkCustodianChecked{
+			ID: event.AggregateID(),
+		}}
 	}
 	return nil
 }
 
-func (CheckPartiesSaga) CheckCustodian(custodianID string) bool {
-	crypto := pkg.NewCryptoClient()
-	legalEntity := types.LegalEntity{URI: custodianID}
-	return crypto.KeyExistsFor(legalEntity)
+func (c CheckPartiesSaga) CheckCustodian(custodianID string) bool {
+	if c.Custodians != nil {
+		return knownParty(c.Custodians, custodianID)
+	}
+	return knownParty(c.Registry, custodianID)
+}
+
+func (c CheckPartiesSaga) CheckActor(actorID string) bool {
+	return knownParty(c.Registry, actorID)
+}
+
+// CheckSubject validates the subject identifier, subjects are not registered parties
+func (c CheckPartiesSaga) CheckSubject(subjectID string) error {
+	return domain.ValidateSubjectID(subjectID)
+}
+
+func knownParty(parties registry.PartyRegistry, id string) bool {
+	_, err := parties.FindParty(id)
+	if err != nil && err != registry.ErrPartyNotFound {
+		log.Printf("[CheckPartiesSaga] could not lookup party %s: %v\n", id, err)
+	}
+	return err == nil
 }
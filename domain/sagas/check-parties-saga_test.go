@@ -0,0 +1,144 @@
+package sagas
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/codelist"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/registry"
+	"github.com/nuts-foundation/nuts-consent-service/registry/memory"
+	"reflect"
+	"testing"
+)
+
+func TestCheckPartiesSaga_RunSaga(t *testing.T) {
+	id := uuid.New()
+	proposedData := events.ProposedData{
+		ID:           id,
+		CustodianID:  "agb:123",
+		SubjectID:    "bsn:999999990",
+		ActorID:      "agb:456",
+		Class:        "medication",
+		PurposeOfUse: "TREAT",
+		Start:        consent.TimeNow(),
+	}
+
+	invalidSubject := proposedData
+	invalidSubject.SubjectID = "bsn:999999991"
+	unknownCodes := proposedData
+	unknownCodes.Class = "everything"
+	unknownCodes.PurposeOfUse = "MARKETING"
+	codes := codelist.Default()
+	knownParties := memory.NewRegistry(registry.Party{ID: "agb:123"}, registry.Party{ID: "agb:456"})
+
+	cases := map[string]struct {
+		saga     CheckPartiesSaga
+		event    eventhorizon.Event
+		commands []eventhorizon.Command
+	}{
+		"valid parties": {
+			CheckPartiesSaga{Codes: codes, Registry: knownParties},
+			eventhorizon.NewEventForAggregate(events.Proposed, proposedData, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+			[]eventhorizon.Command{&consent.MarkCustodianChecked{ID: id}},
+		},
+		"unknown custodian": {
+			CheckPartiesSaga{Codes: codes, Registry: memory.NewRegistry(registry.Party{ID: "agb:456"})},
+			eventhorizon.NewEventForAggregate(events.Proposed, proposedData, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+			[]eventhorizon.Command{&consent.MarkAsErrored{
+				ID:     id,
+				Reason: "custodian agb:123 is not a valid or known party",
+				Code:   domain.ErrorCodeUnknownCustodian,
+				Origin: string(CheckPartiesSagaType),
+			}},
+		},
+		"custodian not managed by this node": {
+			CheckPartiesSaga{Codes: codes, Registry: knownParties, Custodians: memory.NewRegistry(registry.Party{ID: "agb:456"})},
+			eventhorizon.NewEventForAggregate(events.Proposed, proposedData, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+			[]eventhorizon.Command{&consent.MarkAsErrored{
+				ID:     id,
+				Reason: "custodian agb:123 is not a valid or known party",
+				Code:   domain.ErrorCodeUnknownCustodian,
+				Origin: string(CheckPartiesSagaType),
+			}},
+		},
+		"unknown actor": {
+			CheckPartiesSaga{Codes: codes, Registry: memory.NewRegistry(registry.Party{ID: "agb:123"})},
+			eventhorizon.NewEventForAggregate(events.Proposed, proposedData, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+			[]eventhorizon.Command{&consent.MarkAsErrored{
+				ID:     id,
+				Reason: "actor agb:456 is not a valid or known party",
+				Code:   domain.ErrorCodeUnknownActor,
+				Origin: string(CheckPartiesSagaType),
+			}},
+		},
+		"invalid subject": {
+			CheckPartiesSaga{Codes: codes, Registry: knownParties},
+			eventhorizon.NewEventForAggregate(events.Proposed, invalidSubject, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+			[]eventhorizon.Command{&consent.MarkAsErrored{
+				ID:     id,
+				Reason: "subject bsn:999999991 is not valid: invalid BSN: checksum failed",
+				Code:   domain.ErrorCodeInvalidSubject,
+				Origin: string(CheckPartiesSagaType),
+			}},
+		},
+		"every party fails": {
+			CheckPartiesSaga{Codes: codes, Registry: memory.NewRegistry()},
+			eventhorizon.NewEventForAggregate(events.Proposed, invalidSubject, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+			[]eventhorizon.Command{&consent.MarkAsErrored{
+				ID:     id,
+				Reason: "custodian agb:123 is not a valid or known party; actor agb:456 is not a valid or known party; subject bsn:999999991 is not valid: invalid BSN: checksum failed",
+				Code:   domain.ErrorCodeUnknownCustodian,
+				Origin: string(CheckPartiesSagaType),
+			}},
+		},
+		"unknown class and purpose of use": {
+			CheckPartiesSaga{Codes: codes, Registry: knownParties},
+			eventhorizon.NewEventForAggregate(events.Proposed, unknownCodes, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+			[]eventhorizon.Command{&consent.MarkAsErrored{
+				ID:     id,
+				Reason: "unknown consent class: 'everything'; unknown purpose of use: 'MARKETING'",
+				Code:   domain.ErrorCodeUnknownClass,
+				Origin: string(CheckPartiesSagaType),
+			}},
+		},
+		"amended": {
+			CheckPartiesSaga{Codes: codes, Registry: knownParties},
+			eventhorizon.NewEventForAggregate(events.Amended, events.AmendedData{ProposedData: proposedData, Version: 2}, consent.TimeNow(), consent.ConsentAggregateType, id, 6),
+			[]eventhorizon.Command{&consent.MarkCustodianChecked{ID: id}},
+		},
+		"amended with unknown purpose of use": {
+			CheckPartiesSaga{Codes: codes, Registry: knownParties},
+			eventhorizon.NewEventForAggregate(events.Amended, events.AmendedData{ProposedData: events.ProposedData{ID: id, CustodianID: "agb:123", SubjectID: "bsn:999999990", ActorID: "agb:456", Class: "medication", PurposeOfUse: "MARKETING"}, Version: 2}, consent.TimeNow(), consent.ConsentAggregateType, id, 6),
+			[]eventhorizon.Command{&consent.MarkAsErrored{
+				ID:     id,
+				Reason: "unknown purpose of use: 'MARKETING'",
+				Code:   domain.ErrorCodeUnknownPurposeOfUse,
+				Origin: string(CheckPartiesSagaType),
+			}},
+		},
+		"missing event data": {
+			CheckPartiesSaga{Codes: codes, Registry: memory.NewRegistry()},
+			eventhorizon.NewEventForAggregate(events.Proposed, nil, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+			[]eventhorizon.Command{&consent.MarkAsErrored{
+				ID:     id,
+				Reason: "event did not contain proposedData",
+				Code:   domain.ErrorCodeInternal,
+				Origin: string(CheckPartiesSagaType),
+			}},
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			commands := testcase.saga.RunSaga(context.Background(), testcase.event)
+			if !reflect.DeepEqual(commands, testcase.commands) {
+				t.Errorf("test case '%s': incorrect commands", name)
+				t.Logf("exp: %#v\n", testcase.commands)
+				t.Logf("got: %#v\n", commands)
+			}
+		})
+	}
+}
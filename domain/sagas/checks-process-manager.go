@@ -0,0 +1,184 @@
+package sagas
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/saga"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"log"
+	"sync"
+	"time"
+)
+
+const ChecksProcessManagerType saga.Type = "ChecksProcessManager"
+
+// checksDeadline is the name of the deadline of the checks of a consent
+const checksDeadline = "checks"
+
+// CheckProcess is the state of the checks for a single consent
+type CheckProcess struct {
+	ID     uuid.UUID
+	Passed []eh.EventType
+	// Done is set when the checks have completed, timed out or the consent ended otherwise
+	Done bool
+}
+
+func (p CheckProcess) EntityID() uuid.UUID {
+	return p.ID
+}
+
+var _ = eh.Entity(&CheckProcess{})
+
+func (p CheckProcess) passed(check eh.EventType) bool {
+	for _, passed := range p.Passed {
+		if passed == check {
+			return true
+		}
+	}
+	return false
+}
+
+// ChecksProcessManager joins the checks which run in parallel after a consent has been proposed.
+// When all required checks have passed, it hands the completing event to the Sync saga.
+// When they did not pass before the timeout, the deadline it scheduled marks the consent as errored.
+// The deadline is only canceled when the sync has started, so a sync which fails to start also times out.
+type ChecksProcessManager struct {
+	repo           eh.ReadWriteRepo
+	sync           saga.Saga
+	timeout        time.Duration
+	requiredChecks []eh.EventType
+	// mu makes loading and storing a process atomic
+	mu sync.Mutex
+}
+
+// NewChecksProcessManager creates a ChecksProcessManager which stores its state in repo
+func NewChecksProcessManager(repo eh.ReadWriteRepo, sync saga.Saga, timeout time.Duration, requiredChecks ...eh.EventType) *ChecksProcessManager {
+	return &ChecksProcessManager{
+		repo:           repo,
+		sync:           sync,
+		timeout:        timeout,
+		requiredChecks: requiredChecks,
+	}
+}
+
+// MatchEvents returns the events the process manager must receive
+func (pm *ChecksProcessManager) MatchEvents() eh.EventMatcher {
+	return eh.MatchAnyEventOf(append([]eh.EventType{events.Proposed, events.Amended, events.SyncStarted, events.Canceled, events.Errored, events.AmendmentRejected}, pm.requiredChecks...)...)
+}
+
+func (pm *ChecksProcessManager) SagaType() saga.Type {
+	return ChecksProcessManagerType
+}
+
+func (pm *ChecksProcessManager) RunSaga(ctx context.Context, event eh.Event) []eh.Command {
+	log.Printf("[ChecksProcessManager] event: %+v\n", event)
+	completed, err := pm.apply(ctx, event)
+	if err != nil {
+		log.Printf("[ChecksProcessManager] could not update the process: %v\n", err)
+		return []eh.Command{&consent.MarkAsErrored{
+			ID:     event.AggregateID(),
+			Reason: "could not keep track of the checks",
+			Code:   domain.ErrorCodeInternal,
+			Origin: string(ChecksProcessManagerType),
+		}}
+	}
+
+	id := deadline.ID(event.AggregateID(), checksDeadline)
+	switch event.EventType() {
+	case events.Proposed, events.Amended:
+		return []eh.Command{&deadline.ScheduleDeadline{
+			ID:       id,
+			Deadline: event.Timestamp().Add(pm.timeout),
+			Command: &consent.MarkAsErrored{
+				ID:     event.AggregateID(),
+				Reason: fmt.Sprintf("checks did not pass within %s", pm.timeout),
+				Code:   domain.ErrorCodeCheckTimeout,
+				Origin: string(ChecksProcessManagerType),
+			},
+		}}
+	case events.SyncStarted, events.Canceled, events.Errored, events.AmendmentRejected:
+		return []eh.Command{&deadline.CancelDeadline{ID: id}}
+	}
+	if !completed {
+		return nil
+	}
+	log.Printf("[ChecksProcessManager] all checks passed for %s\n", event.AggregateID())
+	return pm.sync.RunSaga(ctx, event)
+}
+
+// apply updates the process for the event and returns true when the event completed the checks
+func (pm *ChecksProcessManager) apply(ctx context.Context, event eh.Event) (bool, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	// an amendment starts the checks again for the new version
+	if event.EventType() == events.Amended {
+		process := &CheckProcess{ID: event.AggregateID()}
+		return false, pm.repo.Save(ctx, process)
+	}
+
+	entity, err := pm.repo.Find(ctx, event.AggregateID())
+	if domain.IsNotFound(err) {
+		if event.EventType() == events.Proposed {
+			process := &CheckProcess{ID: event.AggregateID()}
+			return false, pm.repo.Save(ctx, process)
+		}
+		// the process was never started, nothing to join
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	process := entity.(*CheckProcess)
+	if process.Done {
+		return false, nil
+	}
+
+	switch {
+	case event.EventType() == events.Canceled, event.EventType() == events.Errored, event.EventType() == events.AmendmentRejected:
+		process.Done = true
+		return false, pm.repo.Save(ctx, process)
+	case pm.required(event.EventType()):
+		if !process.passed(event.EventType()) {
+			process.Passed = append(process.Passed, event.EventType())
+		}
+		process.Done = pm.passedAll(process)
+		if err := pm.repo.Save(ctx, process); err != nil {
+			return false, err
+		}
+		return process.Done, nil
+	}
+	return false, nil
+}
+
+func (pm *ChecksProcessManager) required(eventType eh.EventType) bool {
+	for _, check := range pm.requiredChecks {
+		if check == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// passedAll returns true when every required check has passed
+func (pm *ChecksProcessManager) passedAll(process *CheckProcess) bool {
+	for _, check := range pm.requiredChecks {
+		if !process.passed(check) {
+			return false
+		}
+	}
+	return true
+}
+
+// Rebuild clears the state and replays the given events into it, without running the Sync saga
+func (pm *ChecksProcessManager) Rebuild(ctx context.Context, history []eh.Event) error {
+	return domain.Rebuild(ctx, pm.repo, history, func(ctx context.Context, event eh.Event) error {
+		_, err := pm.apply(ctx, event)
+		return err
+	})
+}
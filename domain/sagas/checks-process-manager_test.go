@@ -0,0 +1,230 @@
+package sagas
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/saga"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/eventstore/bolt/bolttest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// syncRecorder is a saga which records the events it is run with
+type syncRecorder struct {
+	events []eh.Event
+}
+
+func (s *syncRecorder) SagaType() saga.Type {
+	return "syncRecorder"
+}
+
+func (s *syncRecorder) RunSaga(ctx context.Context, event eh.Event) []eh.Command {
+	s.events = append(s.events, event)
+	return []eh.Command{&consent.StartSync{ID: event.AggregateID(), SyncID: event.AggregateID()}}
+}
+
+// consentCommands returns the commands for the consent, leaving out the deadlines
+func consentCommands(commands []eh.Command) []eh.Command {
+	var result []eh.Command
+	for _, cmd := range commands {
+		if cmd.AggregateType() == consent.ConsentAggregateType {
+			result = append(result, cmd)
+		}
+	}
+	return result
+}
+
+func TestChecksProcessManager_RunSaga(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	event := func(id uuid.UUID, eventType eh.EventType, version int) eh.Event {
+		return eh.NewEventForAggregate(eventType, nil, start, consent.ConsentAggregateType, id, version)
+	}
+
+	cases := map[string]struct {
+		events       []eh.EventType
+		expectedSync eh.EventType
+	}{
+		"unique, then custodian checked": {
+			[]eh.EventType{events.Proposed, events.Unique, events.CustodianChecked},
+			events.CustodianChecked,
+		},
+		"custodian checked, then unique": {
+			[]eh.EventType{events.Proposed, events.CustodianChecked, events.Unique},
+			events.Unique,
+		},
+		"single check": {
+			[]eh.EventType{events.Proposed, events.Unique},
+			"",
+		},
+		"redelivered check": {
+			[]eh.EventType{events.Proposed, events.Unique, events.Unique},
+			"",
+		},
+		"canceled before the checks completed": {
+			[]eh.EventType{events.Proposed, events.Unique, events.Canceled, events.CustodianChecked},
+			"",
+		},
+		"errored before the checks completed": {
+			[]eh.EventType{events.Proposed, events.Errored, events.Unique, events.CustodianChecked},
+			"",
+		},
+		"amended": {
+			[]eh.EventType{events.Proposed, events.Amended, events.Unique, events.CustodianChecked},
+			events.CustodianChecked,
+		},
+		"amendment rejected before the checks completed": {
+			[]eh.EventType{events.Proposed, events.Amended, events.Unique, events.AmendmentRejected, events.CustodianChecked},
+			"",
+		},
+		"never proposed": {
+			[]eh.EventType{events.Unique, events.CustodianChecked},
+			"",
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			id := uuid.New()
+			recorder := &syncRecorder{}
+			pm := NewChecksProcessManager(memory.NewRepo(), recorder, time.Minute, events.Unique, events.CustodianChecked)
+
+			var commands []eh.Command
+			for i, eventType := range testcase.events {
+				commands = append(commands, consentCommands(pm.RunSaga(context.Background(), event(id, eventType, i+1)))...)
+			}
+
+			if testcase.expectedSync == "" {
+				if len(recorder.events) != 0 || len(commands) != 0 {
+					t.Errorf("expected no sync, got %v", recorder.events)
+				}
+				return
+			}
+			if len(recorder.events) != 1 || recorder.events[0].EventType() != testcase.expectedSync {
+				t.Errorf("expected a single sync for %s, got %v", testcase.expectedSync, recorder.events)
+			}
+			expected := []eh.Command{&consent.StartSync{ID: id, SyncID: id}}
+			if !reflect.DeepEqual(commands, expected) {
+				t.Errorf("expected the commands of the sync saga, got %#v", commands)
+			}
+		})
+	}
+}
+
+func TestChecksProcessManager_Deadline(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	id := uuid.New()
+	deadlineID := deadline.ID(id, checksDeadline)
+	event := func(eventType eh.EventType, version int) eh.Event {
+		return eh.NewEventForAggregate(eventType, nil, start, consent.ConsentAggregateType, id, version)
+	}
+
+	cases := map[string]struct {
+		events   []eh.Event
+		expected []eh.Command
+	}{
+		"proposed": {
+			[]eh.Event{event(events.Proposed, 1)},
+			[]eh.Command{&deadline.ScheduleDeadline{
+				ID:       deadlineID,
+				Deadline: start.Add(time.Minute),
+				Command: &consent.MarkAsErrored{
+					ID:     id,
+					Reason: "checks did not pass within 1m0s",
+					Code:   domain.ErrorCodeCheckTimeout,
+					Origin: string(ChecksProcessManagerType),
+				},
+			}},
+		},
+		"checks passed": {
+			[]eh.Event{event(events.Proposed, 1), event(events.Unique, 2), event(events.CustodianChecked, 3)},
+			[]eh.Command{&consent.StartSync{ID: id, SyncID: id}},
+		},
+		"sync started": {
+			[]eh.Event{event(events.Proposed, 1), event(events.Unique, 2), event(events.CustodianChecked, 3), event(events.SyncStarted, 4)},
+			[]eh.Command{&deadline.CancelDeadline{ID: deadlineID}},
+		},
+		"canceled": {
+			[]eh.Event{event(events.Proposed, 1), event(events.Canceled, 2)},
+			[]eh.Command{&deadline.CancelDeadline{ID: deadlineID}},
+		},
+		"amendment rejected": {
+			[]eh.Event{event(events.Proposed, 1), event(events.Amended, 6), event(events.AmendmentRejected, 7)},
+			[]eh.Command{&deadline.CancelDeadline{ID: deadlineID}},
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			pm := NewChecksProcessManager(memory.NewRepo(), &syncRecorder{}, time.Minute, events.Unique, events.CustodianChecked)
+			var commands []eh.Command
+			for _, event := range testcase.events {
+				commands = pm.RunSaga(ctx, event)
+			}
+			// only the commands of the last event are compared
+			if !reflect.DeepEqual(commands, testcase.expected) {
+				t.Errorf("incorrect commands")
+				t.Logf("exp: %#v", testcase.expected)
+				t.Logf("got: %#v", commands)
+			}
+		})
+	}
+}
+
+func TestChecksProcessManager_Rebuild(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+	recorder := &syncRecorder{}
+	pm := NewChecksProcessManager(memory.NewRepo(), recorder, time.Minute, events.Unique, events.CustodianChecked)
+
+	history := []eh.Event{
+		eh.NewEventForAggregate(events.Proposed, nil, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+		eh.NewEventForAggregate(events.Unique, nil, consent.TimeNow(), consent.ConsentAggregateType, id, 2),
+	}
+	if err := pm.Rebuild(ctx, history); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.events) != 0 {
+		t.Fatalf("expected rebuild not to sync, got %v", recorder.events)
+	}
+
+	pm.RunSaga(ctx, eh.NewEventForAggregate(events.CustodianChecked, nil, consent.TimeNow(), consent.ConsentAggregateType, id, 3))
+	if len(recorder.events) != 1 {
+		t.Errorf("expected the rebuilt process to complete, got %v", recorder.events)
+	}
+}
+
+func TestChecksProcessManager_Restart(t *testing.T) {
+	ctx := context.Background()
+	pending, canceled := uuid.New(), uuid.New()
+	event := func(id uuid.UUID, eventType eh.EventType, version int) eh.Event {
+		return eh.NewEventForAggregate(eventType, nil, consent.TimeNow(), consent.ConsentAggregateType, id, version)
+	}
+	history := bolttest.Restart(t, []eh.Event{
+		event(pending, events.Proposed, 1),
+		event(pending, events.Unique, 2),
+		event(canceled, events.Proposed, 1),
+		event(canceled, events.Unique, 2),
+		event(canceled, events.Canceled, 3),
+	})
+
+	// the service builds a new process manager from the events in the store
+	recorder := &syncRecorder{}
+	pm := NewChecksProcessManager(memory.NewRepo(), recorder, time.Minute, events.Unique, events.CustodianChecked)
+	if err := pm.Rebuild(ctx, history); err != nil {
+		t.Fatal(err)
+	}
+
+	pm.RunSaga(ctx, event(canceled, events.CustodianChecked, 4))
+	pm.RunSaga(ctx, event(pending, events.CustodianChecked, 3))
+	if len(recorder.events) != 1 || recorder.events[0].AggregateID() != pending {
+		t.Errorf("expected only the pending consent to sync, got %v", recorder.events)
+	}
+}
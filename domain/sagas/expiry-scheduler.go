@@ -0,0 +1,145 @@
+package sagas
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/saga"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"log"
+	"sync"
+	"time"
+)
+
+const ExpirySchedulerType saga.Type = "ExpiryScheduler"
+
+// expiryDeadline is the name of the deadline of the expiry of a consent
+const expiryDeadline = "expiry"
+
+// Expiry is the end of the validity period of a single consent
+type Expiry struct {
+	ID  uuid.UUID
+	End time.Time
+	// Amending is set while an amendment is in progress, AmendedEnd replaces End when the amendment completes
+	Amending   bool
+	AmendedEnd time.Time
+}
+
+func (e Expiry) EntityID() uuid.UUID {
+	return e.ID
+}
+
+var _ = eh.Entity(&Expiry{})
+
+// ExpiryScheduler schedules a deadline which expires a completed consent when the End of its validity period passes.
+// A consent is not expired while it is being amended, the amendment may extend it: the deadline is canceled when the
+// amendment starts and scheduled again when it completes or is rejected.
+// The expiries are scheduled as persisted deadlines, so a restart does not lose them.
+type ExpiryScheduler struct {
+	repo eh.ReadWriteRepo
+	// mu makes loading and storing an expiry atomic
+	mu sync.Mutex
+}
+
+// NewExpiryScheduler creates an ExpiryScheduler which stores its state in repo
+func NewExpiryScheduler(repo eh.ReadWriteRepo) *ExpiryScheduler {
+	return &ExpiryScheduler{repo: repo}
+}
+
+// MatchEvents returns the events the scheduler must receive
+func (s *ExpiryScheduler) MatchEvents() eh.EventMatcher {
+	return eh.MatchAnyEventOf(events.Proposed, events.Amended, events.AmendmentRejected, events.Completed, events.Canceled, events.Errored, events.Expired, events.Withdrawn)
+}
+
+func (s *ExpiryScheduler) SagaType() saga.Type {
+	return ExpirySchedulerType
+}
+
+// RunSaga schedules or cancels the expiry deadline of the consent, the deadline.Scheduler dispatches the Expire command
+func (s *ExpiryScheduler) RunSaga(ctx context.Context, event eh.Event) []eh.Command {
+	expiry, err := s.apply(ctx, event)
+	if err != nil {
+		log.Printf("[ExpiryScheduler] could not schedule the expiry of %s: %v\n", event.AggregateID(), err)
+		return nil
+	}
+
+	id := deadline.ID(event.AggregateID(), expiryDeadline)
+	switch event.EventType() {
+	case events.Completed, events.AmendmentRejected:
+		if expiry == nil {
+			return nil
+		}
+		// a consent without End never expires, its amendment may have removed the End
+		if expiry.End.IsZero() {
+			return []eh.Command{&deadline.CancelDeadline{ID: id}}
+		}
+		return []eh.Command{&deadline.ScheduleDeadline{
+			ID:       id,
+			Deadline: expiry.End,
+			Command:  &consent.Expire{ID: event.AggregateID()},
+		}}
+	case events.Amended, events.Canceled, events.Errored, events.Withdrawn:
+		return []eh.Command{&deadline.CancelDeadline{ID: id}}
+	}
+	return nil
+}
+
+// apply updates the expiry for the event and returns it, nil when the consent no longer expires.
+// The expiry is removed when the consent has ended, the Expired event is the only one which marks it expired.
+func (s *ExpiryScheduler) apply(ctx context.Context, event eh.Event) (*Expiry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event.EventType() == events.Proposed {
+		data, ok := event.Data().(events.ProposedData)
+		if !ok {
+			return nil, nil
+		}
+		// a consent without End never expires, but an amendment may set one
+		expiry := &Expiry{ID: event.AggregateID(), End: data.End}
+		return expiry, s.repo.Save(ctx, expiry)
+	}
+
+	entity, err := s.repo.Find(ctx, event.AggregateID())
+	if domain.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	expiry := entity.(*Expiry)
+	switch event.EventType() {
+	case events.Amended:
+		data, ok := event.Data().(events.AmendedData)
+		if !ok {
+			return expiry, nil
+		}
+		expiry.Amending = true
+		expiry.AmendedEnd = data.End
+	case events.AmendmentRejected:
+		expiry.Amending = false
+	case events.Completed:
+		if expiry.Amending {
+			expiry.End = expiry.AmendedEnd
+			expiry.Amending = false
+		}
+	default:
+		err := s.repo.Remove(ctx, expiry.ID)
+		if domain.IsNotFound(err) {
+			err = nil
+		}
+		return nil, err
+	}
+	return expiry, s.repo.Save(ctx, expiry)
+}
+
+// Rebuild clears the state and replays the given events into it
+func (s *ExpiryScheduler) Rebuild(ctx context.Context, history []eh.Event) error {
+	return domain.Rebuild(ctx, s.repo, history, func(ctx context.Context, event eh.Event) error {
+		_, err := s.apply(ctx, event)
+		return err
+	})
+}
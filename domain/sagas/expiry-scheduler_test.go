@@ -0,0 +1,202 @@
+package sagas
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/eventstore/bolt/bolttest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpiryScheduler_RunSaga(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+	proposed := func(id uuid.UUID, end time.Time) eh.Event {
+		return eh.NewEventForAggregate(events.Proposed, events.ProposedData{ID: id, Start: start, End: end}, start, consent.ConsentAggregateType, id, 1)
+	}
+	event := func(id uuid.UUID, eventType eh.EventType, version int) eh.Event {
+		return eh.NewEventForAggregate(eventType, nil, start, consent.ConsentAggregateType, id, version)
+	}
+	amended := func(id uuid.UUID, end time.Time) eh.Event {
+		data := events.AmendedData{ProposedData: events.ProposedData{ID: id, Start: start, End: end}, Version: 2}
+		return eh.NewEventForAggregate(events.Amended, data, start, consent.ConsentAggregateType, id, 6)
+	}
+	schedule := func(id uuid.UUID, end time.Time) []eh.Command {
+		return []eh.Command{&deadline.ScheduleDeadline{ID: deadline.ID(id, expiryDeadline), Deadline: end, Command: &consent.Expire{ID: id}}}
+	}
+	cancel := func(id uuid.UUID) []eh.Command {
+		return []eh.Command{&deadline.CancelDeadline{ID: deadline.ID(id, expiryDeadline)}}
+	}
+
+	cases := map[string]struct {
+		history  func(id uuid.UUID) []eh.Event
+		expected func(id uuid.UUID) []eh.Command
+	}{
+		"proposed": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{proposed(id, end)}
+			},
+			func(id uuid.UUID) []eh.Command { return nil },
+		},
+		"completed": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{proposed(id, end), event(id, events.Completed, 5)}
+			},
+			func(id uuid.UUID) []eh.Command { return schedule(id, end) },
+		},
+		"errored": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{proposed(id, end), event(id, events.Errored, 2)}
+			},
+			cancel,
+		},
+		"withdrawn": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{proposed(id, end), event(id, events.Completed, 5), event(id, events.Withdrawn, 6)}
+			},
+			cancel,
+		},
+		"expired": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{proposed(id, end), event(id, events.Completed, 5), event(id, events.Expired, 6)}
+			},
+			func(id uuid.UUID) []eh.Command { return nil },
+		},
+		"amendment in progress": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{proposed(id, end), event(id, events.Completed, 5), amended(id, end.AddDate(1, 0, 0))}
+			},
+			cancel,
+		},
+		"extended by amendment": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{proposed(id, end), event(id, events.Completed, 5), amended(id, end.AddDate(1, 0, 0)), event(id, events.Completed, 10)}
+			},
+			func(id uuid.UUID) []eh.Command { return schedule(id, end.AddDate(1, 0, 0)) },
+		},
+		"end set by amendment": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{proposed(id, time.Time{}), event(id, events.Completed, 5), amended(id, end), event(id, events.Completed, 10)}
+			},
+			func(id uuid.UUID) []eh.Command { return schedule(id, end) },
+		},
+		"end removed by amendment": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{proposed(id, end), event(id, events.Completed, 5), amended(id, time.Time{}), event(id, events.Completed, 10)}
+			},
+			cancel,
+		},
+		"amendment rejected": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{proposed(id, end), event(id, events.Completed, 5), amended(id, end.AddDate(1, 0, 0)), event(id, events.AmendmentRejected, 8)}
+			},
+			func(id uuid.UUID) []eh.Command { return schedule(id, end) },
+		},
+		"without end": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{proposed(id, time.Time{}), event(id, events.Completed, 5)}
+			},
+			cancel,
+		},
+		"never proposed": {
+			func(id uuid.UUID) []eh.Event {
+				return []eh.Event{event(id, events.Completed, 5)}
+			},
+			func(id uuid.UUID) []eh.Command { return nil },
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			id := uuid.New()
+			scheduler := NewExpiryScheduler(memory.NewRepo())
+			var commands []eh.Command
+			for _, event := range testcase.history(id) {
+				commands = scheduler.RunSaga(ctx, event)
+			}
+
+			// only the commands of the last event are compared
+			expected := testcase.expected(id)
+			if !reflect.DeepEqual(commands, expected) {
+				t.Errorf("incorrect commands")
+				t.Logf("exp: %#v", expected)
+				t.Logf("got: %#v", commands)
+			}
+		})
+	}
+}
+
+func TestExpiryScheduler_Rebuild(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+	end := time.Date(2021, time.January, 1, 12, 0, 0, 0, time.UTC)
+	amendedEnd := end.AddDate(1, 0, 0)
+	scheduler := NewExpiryScheduler(memory.NewRepo())
+
+	history := []eh.Event{
+		eh.NewEventForAggregate(events.Proposed, events.ProposedData{ID: id, End: end}, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+		eh.NewEventForAggregate(events.Completed, nil, consent.TimeNow(), consent.ConsentAggregateType, id, 5),
+		eh.NewEventForAggregate(events.Amended, events.AmendedData{ProposedData: events.ProposedData{ID: id, End: amendedEnd}, Version: 2}, consent.TimeNow(), consent.ConsentAggregateType, id, 6),
+	}
+	if err := scheduler.Rebuild(ctx, history); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := scheduler.RunSaga(ctx, eh.NewEventForAggregate(events.Completed, nil, consent.TimeNow(), consent.ConsentAggregateType, id, 10))
+	expected := []eh.Command{&deadline.ScheduleDeadline{ID: deadline.ID(id, expiryDeadline), Deadline: amendedEnd, Command: &consent.Expire{ID: id}}}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected the rebuilt expiry to schedule the amended end, got %#v", commands)
+	}
+}
+
+func TestExpiryScheduler_Restart(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	end := at.AddDate(1, 0, 0)
+	extended, rejected := uuid.New(), uuid.New()
+	history := func(id uuid.UUID) []eh.Event {
+		return []eh.Event{
+			eh.NewEventForAggregate(events.Proposed, events.ProposedData{ID: id, Start: at, End: end}, at, consent.ConsentAggregateType, id, 1),
+			eh.NewEventForAggregate(events.Completed, nil, at, consent.ConsentAggregateType, id, 2),
+			eh.NewEventForAggregate(events.Amended, events.AmendedData{ProposedData: events.ProposedData{ID: id, Start: at, End: end.AddDate(1, 0, 0)}, Version: 2}, at, consent.ConsentAggregateType, id, 3),
+		}
+	}
+	stored := bolttest.Restart(t, append(history(extended), history(rejected)...))
+
+	// the service builds a new scheduler from the events in the store
+	scheduler := NewExpiryScheduler(memory.NewRepo())
+	if err := scheduler.Rebuild(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		event    eh.Event
+		expected []eh.Command
+	}{
+		"amendment completed": {
+			eh.NewEventForAggregate(events.Completed, nil, at, consent.ConsentAggregateType, extended, 4),
+			[]eh.Command{&deadline.ScheduleDeadline{ID: deadline.ID(extended, expiryDeadline), Deadline: end.AddDate(1, 0, 0), Command: &consent.Expire{ID: extended}}},
+		},
+		"amendment rejected": {
+			eh.NewEventForAggregate(events.AmendmentRejected, nil, at, consent.ConsentAggregateType, rejected, 4),
+			[]eh.Command{&deadline.ScheduleDeadline{ID: deadline.ID(rejected, expiryDeadline), Deadline: end, Command: &consent.Expire{ID: rejected}}},
+		},
+	}
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			commands := scheduler.RunSaga(ctx, testcase.event)
+			if !reflect.DeepEqual(commands, testcase.expected) {
+				t.Errorf("incorrect commands")
+				t.Logf("exp: %#v", testcase.expected)
+				t.Logf("got: %#v", commands)
+			}
+		})
+	}
+}
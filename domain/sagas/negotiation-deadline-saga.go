@@ -0,0 +1,50 @@
+package sagas
+
+import (
+	"context"
+	"fmt"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/saga"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"time"
+)
+
+const NegotiationDeadlineSagaType saga.Type = "NegotiationDeadlineSaga"
+
+// negotiationDeadline is the name of the deadline of the negotiation of a consent
+const negotiationDeadline = "negotiation"
+
+// NegotiationDeadlineSaga errors a consent of which the negotiation did not end within Timeout after syncing started.
+// The deadline is canceled when the consent is completed, errored or canceled before, or when its amendment is rejected.
+type NegotiationDeadlineSaga struct {
+	Timeout time.Duration
+}
+
+// MatchEvents returns the events the saga must receive
+func (s NegotiationDeadlineSaga) MatchEvents() eh.EventMatcher {
+	return eh.MatchAnyEventOf(events.SyncStarted, events.Completed, events.Errored, events.Canceled, events.AmendmentRejected)
+}
+
+func (s NegotiationDeadlineSaga) SagaType() saga.Type {
+	return NegotiationDeadlineSagaType
+}
+
+func (s NegotiationDeadlineSaga) RunSaga(ctx context.Context, event eh.Event) []eh.Command {
+	id := deadline.ID(event.AggregateID(), negotiationDeadline)
+	if event.EventType() != events.SyncStarted {
+		return []eh.Command{&deadline.CancelDeadline{ID: id}}
+	}
+	return []eh.Command{&deadline.ScheduleDeadline{
+		ID:       id,
+		Deadline: event.Timestamp().Add(s.Timeout),
+		Command: &consent.MarkAsErrored{
+			ID:     event.AggregateID(),
+			Reason: fmt.Sprintf("negotiation did not complete within %s", s.Timeout),
+			Code:   domain.ErrorCodeNegotiationTimeout,
+			Origin: string(NegotiationDeadlineSagaType),
+		},
+	}}
+}
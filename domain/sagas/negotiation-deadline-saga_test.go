@@ -0,0 +1,58 @@
+package sagas
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNegotiationDeadlineSaga_RunSaga(t *testing.T) {
+	id := uuid.New()
+	deadlineID := deadline.ID(id, "negotiation")
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := map[string]struct {
+		eventType        eh.EventType
+		expectedCommands []eh.Command
+	}{
+		"sync started": {
+			events.SyncStarted,
+			[]eh.Command{&deadline.ScheduleDeadline{
+				ID:       deadlineID,
+				Deadline: now.Add(time.Hour),
+				Command:  &consent.MarkAsErrored{ID: id, Reason: "negotiation did not complete within 1h0m0s", Code: domain.ErrorCodeNegotiationTimeout, Origin: string(NegotiationDeadlineSagaType)},
+			}},
+		},
+		"completed": {
+			events.Completed,
+			[]eh.Command{&deadline.CancelDeadline{ID: deadlineID}},
+		},
+		"errored": {
+			events.Errored,
+			[]eh.Command{&deadline.CancelDeadline{ID: deadlineID}},
+		},
+		"canceled": {
+			events.Canceled,
+			[]eh.Command{&deadline.CancelDeadline{ID: deadlineID}},
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			event := eh.NewEventForAggregate(testcase.eventType, nil, now, consent.ConsentAggregateType, id, 4)
+			commands := NegotiationDeadlineSaga{Timeout: time.Hour}.RunSaga(context.Background(), event)
+			if !reflect.DeepEqual(commands, testcase.expectedCommands) {
+				t.Errorf("incorrect commands")
+				t.Logf("exp: %#v", testcase.expectedCommands)
+				t.Logf("got: %#v", commands)
+			}
+		})
+	}
+}
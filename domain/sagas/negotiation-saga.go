@@ -0,0 +1,44 @@
+package sagas
+
+import (
+	"context"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/saga"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"log"
+)
+
+const NegotiationSagaType saga.Type = "NegotiationSaga"
+
+// NegotiationSaga completes or errors a consent when the negotiation of its contract has ended
+type NegotiationSaga struct {
+}
+
+// MatchEvents returns the events the saga must receive
+func (s NegotiationSaga) MatchEvents() eh.EventMatcher {
+	return eh.MatchAnyEventOf(events.NegotiationCompleted, events.NegotiationFailed)
+}
+
+func (s NegotiationSaga) SagaType() saga.Type {
+	return NegotiationSagaType
+}
+
+func (s NegotiationSaga) RunSaga(ctx context.Context, event eh.Event) []eh.Command {
+	log.Printf("[NegotiationSaga] event: %+v\n", event)
+
+	switch data := event.Data().(type) {
+	case events.NegotiationCompletedData:
+		return []eh.Command{&consent.MarkAsCompleted{ID: data.ConsentID}}
+	case events.NegotiationFailedData:
+		code := data.Code
+		if code == "" {
+			code = domain.ErrorCodeNegotiationFailed
+		}
+		return []eh.Command{&consent.MarkAsErrored{ID: data.ConsentID, Reason: "negotiation failed: " + data.Reason, Code: code, Origin: string(NegotiationSagaType)}}
+	default:
+		log.Printf("[NegotiationSaga] unexpected event '%s'\n", event.EventType())
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package sagas
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain/negotiation"
+	"reflect"
+	"testing"
+)
+
+func TestNegotiationSaga_RunSaga(t *testing.T) {
+	consentID := uuid.New()
+	syncID := uuid.New()
+
+	cases := map[string]struct {
+		eventType        eh.EventType
+		data             eh.EventData
+		expectedCommands []eh.Command
+	}{
+		"completed": {
+			events.NegotiationCompleted,
+			events.NegotiationCompletedData{ConsentID: consentID},
+			[]eh.Command{&consent.MarkAsCompleted{ID: consentID}},
+		},
+		"failed": {
+			events.NegotiationFailed,
+			events.NegotiationFailedData{ConsentID: consentID, Reason: "endpoint unavailable"},
+			[]eh.Command{&consent.MarkAsErrored{ID: consentID, Reason: "negotiation failed: endpoint unavailable", Code: domain.ErrorCodeNegotiationFailed, Origin: string(NegotiationSagaType)}},
+		},
+		"rejected": {
+			events.NegotiationFailed,
+			events.NegotiationFailedData{ConsentID: consentID, Reason: "vendor rejected", Code: domain.ErrorCodeContractRejected},
+			[]eh.Command{&consent.MarkAsErrored{ID: consentID, Reason: "negotiation failed: vendor rejected", Code: domain.ErrorCodeContractRejected, Origin: string(NegotiationSagaType)}},
+		},
+		"party acknowledged": {
+			events.PartyAcknowledged,
+			events.PartyAcknowledgedData{ConsentID: consentID, PartyID: "agb:123"},
+			nil,
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			event := eh.NewEventForAggregate(testcase.eventType, testcase.data, consent.TimeNow(), negotiation.ConsentNegotiationAggregateType, syncID, 2)
+			commands := NegotiationSaga{}.RunSaga(context.Background(), event)
+			if !reflect.DeepEqual(commands, testcase.expectedCommands) {
+				t.Errorf("incorrect commands")
+				t.Logf("exp: %#v", testcase.expectedCommands)
+				t.Logf("got: %#v", commands)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package sagas
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+)
+
+const PropagateWithdrawalCmdType = eh.CommandType("consent:propagate-withdrawal")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &PropagateWithdrawal{}
+	})
+}
+
+// PropagateWithdrawal informs all parties of a withdrawn consent. It is handled by the WithdrawalPropagator and
+// scheduled as deadline, so a propagation which has not succeeded yet survives a restart.
+// Attempt is the number of the try, starting at 1.
+type PropagateWithdrawal struct {
+	ID          uuid.UUID
+	WithdrawnBy string
+	Reason      string `eh:"optional"`
+	Attempt     int
+}
+
+func (cmd PropagateWithdrawal) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd PropagateWithdrawal) AggregateType() eh.AggregateType {
+	return consent.ConsentAggregateType
+}
+
+func (cmd PropagateWithdrawal) CommandType() eh.CommandType {
+	return PropagateWithdrawalCmdType
+}
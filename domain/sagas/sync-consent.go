@@ -0,0 +1,38 @@
+package sagas
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+)
+
+const SyncConsentCmdType = eh.CommandType("consent:sync")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &SyncConsent{}
+	})
+}
+
+// SyncConsent starts the negotiation of a consent of which the checks have passed under SyncID, and then starts syncing
+// the consent. It is handled by the SyncStarter and scheduled as deadline, so a sync which has not started yet
+// survives a restart. Version is the version of the consent when its checks passed, Attempt is the number of the try,
+// starting at 1.
+type SyncConsent struct {
+	ID      uuid.UUID
+	SyncID  uuid.UUID
+	Version int
+	Attempt int
+}
+
+func (cmd SyncConsent) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd SyncConsent) AggregateType() eh.AggregateType {
+	return consent.ConsentAggregateType
+}
+
+func (cmd SyncConsent) CommandType() eh.CommandType {
+	return SyncConsentCmdType
+}
@@ -2,18 +2,32 @@ package sagas
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/eventhandler/saga"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
 	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
 	"github.com/nuts-foundation/nuts-consent-service/domain/events"
-	"github.com/nuts-foundation/nuts-consent-service/negotiator/local"
+	"github.com/nuts-foundation/nuts-consent-service/negotiator"
 	"log"
+	"time"
 )
 
 const SyncSagaType saga.Type = "SyncSagaType"
 
+// DefaultSyncRetry is the schedule of the attempts of the SyncStarter to load the consent and to start the negotiation
+var DefaultSyncRetry = deadline.Retry{Attempts: 3, Delay: 5 * time.Second}
+
+var errWrongEntityType = errors.New("entity is not of type ConsentNegotiation")
+
+// SyncSaga schedules the start of the negotiation of the contract once the checks have passed.
+// The start is a deadline which passes immediately, the deadline.Scheduler dispatches it to the SyncStarter outside
+// the event handler. The sync id is chosen here, so every attempt uses the same one and a retry never starts a
+// second negotiation.
 type SyncSaga struct {
-	NegotiationRepo eh.ReadWriteRepo
 }
 
 func (s SyncSaga) SagaType() saga.Type {
@@ -23,34 +37,103 @@ func (s SyncSaga) SagaType() saga.Type {
 func (s SyncSaga) RunSaga(ctx context.Context, event eh.Event) []eh.Command {
 	log.Printf("[SyncSaga] event: %+v\n", event)
 
+	// The ChecksProcessManager runs this saga with the event that completed the checks
 	switch event.EventType() {
-	case events.Unique:
-		log.Println("[SyncSaga] Consent is unique, let's sync!")
-
-		// make sure we get the latest version
-		versionedCtx, _ := eh.NewContextWithMinVersionWait(ctx, event.Version())
-		entity, err := s.NegotiationRepo.Find(versionedCtx, event.AggregateID())
-		if err != nil {
-			panic(err)
-		}
-		negotiation, ok := entity.(*consent.ConsentNegotiation)
-		if !ok {
-			log.Panic("entity is not of type ConsentNegotiation")
-		}
-
-		log.Printf("%+v\n", negotiation)
-
-		syncId, err := local.LocalNegotiator{}.Start(negotiation.PartyIDs, negotiation.Contract)
-		if err != nil {
-			log.Printf("could not start the sync: %+v", err)
-			// Todo: return command mark-as-errored
-		}
-		return []eh.Command{&consent.StartSync{
-			ID:     event.AggregateID(),
-			SyncID: syncId,
+	case events.Unique, events.CustodianChecked:
+		log.Println("[SyncSaga] Consent is unique and the custodian is checked, let's sync!")
+		sync := &SyncConsent{ID: event.AggregateID(), SyncID: uuid.New(), Version: event.Version(), Attempt: 1}
+		return []eh.Command{&deadline.ScheduleDeadline{
+			ID:       syncDeadline(sync.ID, sync.Attempt),
+			Deadline: event.Timestamp(),
+			Command:  sync,
 		}}
 	default:
 		log.Printf("[SyncSaga] unknown eventtype '%s'\n", event.EventType())
 	}
 	return nil
 }
+
+// syncDeadline returns the ID of the deadline of an attempt to start the sync of a consent
+func syncDeadline(consentID uuid.UUID, attempt int) uuid.UUID {
+	return deadline.ID(consentID, fmt.Sprintf("sync/%d", attempt))
+}
+
+// SyncStarter starts the negotiation of the contract with all parties and then starts syncing the consent.
+// When the consent could not be loaded or the negotiation could not be started, it schedules the next attempt as
+// deadline. When the attempts are used up, it marks the consent as errored.
+type SyncStarter struct {
+	NegotiationRepo eh.ReadRepo
+	Negotiator      negotiator.Negotiator
+	// CommandHandler starts syncing or errors the consent and schedules the next attempts
+	CommandHandler eh.CommandHandler
+	// Retry is the schedule of the attempts, DefaultSyncRetry when not set
+	Retry deadline.Retry
+}
+
+var _ = eh.CommandHandler(&SyncStarter{})
+
+// HandleCommand implements the HandleCommand method of the eventhorizon.CommandHandler interface
+func (s *SyncStarter) HandleCommand(ctx context.Context, command eh.Command) error {
+	cmd, ok := command.(*SyncConsent)
+	if !ok {
+		return domain.ErrUnknownCommand
+	}
+
+	negotiation, err := s.findNegotiation(ctx, cmd)
+	if errors.Is(err, errWrongEntityType) {
+		// another attempt will not help
+		return s.fail(ctx, cmd, domain.ErrorCodeInternal, fmt.Errorf("could not load the consent for syncing: %w", err))
+	}
+	if err != nil {
+		return s.retry(ctx, cmd, domain.ErrorCodeInternal, fmt.Errorf("could not load the consent for syncing: %w", err))
+	}
+	if err := s.Negotiator.Start(cmd.SyncID, negotiation.ID, negotiation.Parties, negotiation.Contract); err != nil {
+		return s.retry(ctx, cmd, domain.ErrorCodeNegotiationFailed, fmt.Errorf("could not start the negotiation: %w", err))
+	}
+	return s.CommandHandler.HandleCommand(ctx, &consent.StartSync{ID: cmd.ID, SyncID: cmd.SyncID})
+}
+
+// findNegotiation loads the read model with at least the version of the consent when its checks passed
+func (s *SyncStarter) findNegotiation(ctx context.Context, cmd *SyncConsent) (*consent.ConsentNegotiation, error) {
+	versionedCtx, cancel := eh.NewContextWithMinVersionWait(ctx, cmd.Version)
+	defer cancel()
+	entity, err := s.NegotiationRepo.Find(versionedCtx, cmd.ID)
+	if err != nil {
+		return nil, err
+	}
+	negotiation, ok := entity.(*consent.ConsentNegotiation)
+	if !ok {
+		return nil, errWrongEntityType
+	}
+	return negotiation, nil
+}
+
+// retry schedules the next attempt, the consent is marked as errored with code when the attempts are used up
+func (s *SyncStarter) retry(ctx context.Context, cmd *SyncConsent, code domain.ErrorCode, cause error) error {
+	retry := s.Retry
+	if retry.Attempts < 1 {
+		retry = DefaultSyncRetry
+	}
+	next := *cmd
+	next.Attempt++
+	scheduled, err := retry.Schedule(ctx, s.CommandHandler, syncDeadline(next.ID, next.Attempt), cmd.Attempt, &next)
+	if err != nil {
+		return err
+	}
+	if !scheduled {
+		return s.fail(ctx, cmd, code, cause)
+	}
+	log.Printf("[SyncStarter] attempt %d of %d to sync consent %s failed: %v\n", cmd.Attempt, retry.Attempts, cmd.ID, cause)
+	return nil
+}
+
+// fail marks the consent as errored
+func (s *SyncStarter) fail(ctx context.Context, cmd *SyncConsent, code domain.ErrorCode, cause error) error {
+	log.Printf("[SyncStarter] could not sync consent %s: %v\n", cmd.ID, cause)
+	return s.CommandHandler.HandleCommand(ctx, &consent.MarkAsErrored{
+		ID:     cmd.ID,
+		Reason: cause.Error(),
+		Code:   code,
+		Origin: string(SyncSagaType),
+	})
+}
@@ -0,0 +1,174 @@
+package sagas
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain/negotiation"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// failingNegotiator fails the first Failures calls to Start, it records the sync ids it is started with
+type failingNegotiator struct {
+	Failures int
+	calls    int
+	syncIDs  []uuid.UUID
+}
+
+func (n *failingNegotiator) Start(syncID uuid.UUID, consentID uuid.UUID, parties []negotiation.Party, contents string) error {
+	n.calls++
+	n.syncIDs = append(n.syncIDs, syncID)
+	if n.calls <= n.Failures {
+		return errors.New("endpoint unavailable")
+	}
+	return nil
+}
+
+func (n *failingNegotiator) Withdraw(consentID uuid.UUID, syncID uuid.UUID, parties []negotiation.Party, withdrawnBy string, reason string) error {
+	n.calls++
+	if n.calls <= n.Failures {
+		return errors.New("endpoint unavailable")
+	}
+	return nil
+}
+
+func TestSyncSaga_RunSaga(t *testing.T) {
+	id := uuid.New()
+	at := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	event := eh.NewEventForAggregate(events.Unique, nil, at, consent.ConsentAggregateType, id, 3)
+
+	commands := SyncSaga{}.RunSaga(context.Background(), event)
+	if len(commands) != 1 {
+		t.Fatalf("expected a single command, got %#v", commands)
+	}
+	var syncID uuid.UUID
+	if cmd, ok := commands[0].(*deadline.ScheduleDeadline); ok {
+		if sync, ok := cmd.Command.(*SyncConsent); ok {
+			syncID = sync.SyncID
+		}
+	}
+	if syncID == uuid.Nil {
+		t.Errorf("expected a sync id")
+	}
+	expected := []eh.Command{&deadline.ScheduleDeadline{
+		ID:       syncDeadline(id, 1),
+		Deadline: at,
+		Command:  &SyncConsent{ID: id, SyncID: syncID, Version: 3, Attempt: 1},
+	}}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("incorrect commands")
+		t.Logf("exp: %#v", expected)
+		t.Logf("got: %#v", commands)
+	}
+}
+
+func TestSyncStarter_HandleCommand(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	deadline.TimeNow = func() time.Time {
+		return now
+	}
+	defer func() {
+		deadline.TimeNow = time.Now
+	}()
+
+	id := uuid.New()
+	syncID := uuid.New()
+	negotiationRepo := func() eh.ReadWriteRepo {
+		repo := memory.NewRepo()
+		if err := repo.Save(context.Background(), &consent.ConsentNegotiation{ID: id, Version: 3}); err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+	retry := func(attempt int, delay time.Duration) []eh.Command {
+		return []eh.Command{&deadline.ScheduleDeadline{
+			ID:       syncDeadline(id, attempt),
+			Deadline: now.Add(delay),
+			Command:  &SyncConsent{ID: id, SyncID: syncID, Version: 3, Attempt: attempt},
+		}}
+	}
+	errored := func(reason string, code domain.ErrorCode) []eh.Command {
+		return []eh.Command{&consent.MarkAsErrored{ID: id, Reason: reason, Code: code, Origin: string(SyncSagaType)}}
+	}
+
+	cases := map[string]struct {
+		repo          eh.ReadWriteRepo
+		negotiator    *failingNegotiator
+		attempt       int
+		scheduleErr   error
+		expectedCalls int
+		expected      []eh.Command
+		expectedErr   bool
+	}{
+		"sync started": {
+			negotiationRepo(), &failingNegotiator{}, 1, nil,
+			1, []eh.Command{&consent.StartSync{ID: id, SyncID: syncID}}, false,
+		},
+		"first attempt fails": {
+			negotiationRepo(), &failingNegotiator{Failures: 1}, 1, nil,
+			1, retry(2, 5*time.Second), false,
+		},
+		"second attempt fails": {
+			negotiationRepo(), &failingNegotiator{Failures: 1}, 2, nil,
+			1, retry(3, 10*time.Second), false,
+		},
+		"last attempt fails": {
+			negotiationRepo(), &failingNegotiator{Failures: 1}, DefaultSyncRetry.Attempts, nil,
+			1, errored("could not start the negotiation: endpoint unavailable", domain.ErrorCodeNegotiationFailed), false,
+		},
+		"repo fails": {
+			&mocks.Repo{LoadErr: errors.New("connection lost")}, &failingNegotiator{}, 1, nil,
+			0, retry(2, 5*time.Second), false,
+		},
+		"repo keeps failing": {
+			&mocks.Repo{LoadErr: errors.New("connection lost")}, &failingNegotiator{}, DefaultSyncRetry.Attempts, nil,
+			0, errored("could not load the consent for syncing: connection lost", domain.ErrorCodeInternal), false,
+		},
+		"wrong entity type": {
+			&mocks.Repo{Entity: &mocks.SimpleModel{ID: id}}, &failingNegotiator{}, 1, nil,
+			0, errored("could not load the consent for syncing: entity is not of type ConsentNegotiation", domain.ErrorCodeInternal), false,
+		},
+		"next attempt already scheduled": {
+			negotiationRepo(), &failingNegotiator{Failures: 1}, 1, domain.InvalidTransitionError{State: "scheduled", Command: string(deadline.ScheduleDeadlineCmdType)},
+			1, retry(2, 5*time.Second), false,
+		},
+		"next attempt not scheduled": {
+			negotiationRepo(), &failingNegotiator{Failures: 1}, 1, errors.New("version conflict"),
+			1, retry(2, 5*time.Second), true,
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			recorder := &commandRecorder{err: testcase.scheduleErr}
+			starter := &SyncStarter{NegotiationRepo: testcase.repo, Negotiator: testcase.negotiator, CommandHandler: recorder}
+
+			err := starter.HandleCommand(context.Background(), &SyncConsent{ID: id, SyncID: syncID, Version: 3, Attempt: testcase.attempt})
+			if (err != nil) != testcase.expectedErr {
+				t.Errorf("unexpected error result: %v", err)
+			}
+			if testcase.negotiator.calls != testcase.expectedCalls {
+				t.Errorf("expected %d negotiator calls, got %d", testcase.expectedCalls, testcase.negotiator.calls)
+			}
+			for _, called := range testcase.negotiator.syncIDs {
+				if called != syncID {
+					t.Errorf("expected the negotiation to be started under %s, got %s", syncID, called)
+				}
+			}
+			if !reflect.DeepEqual(recorder.commands, testcase.expected) {
+				t.Errorf("incorrect commands")
+				t.Logf("exp: %#v", testcase.expected)
+				t.Logf("got: %#v", recorder.commands)
+			}
+		})
+	}
+}
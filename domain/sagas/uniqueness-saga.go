@@ -2,23 +2,32 @@ package sagas
 
 import (
 	"context"
+	"fmt"
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/eventhandler/saga"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
 	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
 	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain/uniqueness"
 	"log"
 )
 
 type UniquenessSaga struct {
-	existingIds []string
+	index *uniqueness.Index
 }
 
-func NewUniquenessSaga() *UniquenessSaga {
-	return &UniquenessSaga{existingIds: make([]string, 0)}
+func NewUniquenessSaga(index *uniqueness.Index) *UniquenessSaga {
+	return &UniquenessSaga{index: index}
 }
 
 const UniquenessSagaType saga.Type = "ConsentUniquenessSaga"
 
+// MatchEvents returns the events the saga must receive
+func (s UniquenessSaga) MatchEvents() eh.EventMatcher {
+	types := append([]eh.EventType{events.Proposed}, uniqueness.AmendingEvents...)
+	return eh.MatchAnyEventOf(append(types, uniqueness.ReleasingEvents...)...)
+}
+
 func (s UniquenessSaga) SagaType() saga.Type {
 	return UniquenessSagaType
 }
@@ -26,27 +35,38 @@ func (s UniquenessSaga) SagaType() saga.Type {
 func (s *UniquenessSaga) RunSaga(ctx context.Context, event eh.Event) []eh.Command {
 	log.Printf("[UniquenessSaga] event: %+v\n", event)
 	switch event.EventType() {
-	case events.Proposed:
-		data, ok := event.Data().(events.ProposedData)
-		if ok {
-			id := data.CustodianID + data.SubjectID + data.ActorID
-			log.Printf("[UniquenessSaga] checking duplicates for: %v\n", id)
-			for _, existingId := range s.existingIds {
-				log.Printf("[UniquenessSaga] against known id: %v\n", existingId)
-				if id == existingId {
-					log.Println("[UniquenessSaga] duplicate found!")
-					return []eh.Command{&consent.Cancel{
-						ID:     event.AggregateID(),
-						Reason: "duplicate consent",
-					}}
-				}
+	case events.Proposed, events.Amended:
+		// an amendment is checked like a proposal, a duplicate amendment is rejected by the aggregate
+		if _, ok := events.Terms(event); ok {
+			owner, err := s.index.ApplyEvent(ctx, event)
+			if err != nil {
+				log.Printf("[UniquenessSaga] could not check uniqueness: %v\n", err)
+				return []eh.Command{&consent.MarkAsErrored{
+					ID:     event.AggregateID(),
+					Reason: "could not check uniqueness",
+					Code:   domain.ErrorCodeInternal,
+					Origin: string(UniquenessSagaType),
+				}}
+			}
+			if owner != event.AggregateID() {
+				log.Printf("[UniquenessSaga] duplicate found! overlaps with %s\n", owner)
+				return []eh.Command{&consent.Cancel{
+					ID:     event.AggregateID(),
+					Reason: fmt.Sprintf("duplicate consent: overlaps with %s", owner),
+					Code:   domain.ErrorCodeDuplicate,
+					Origin: string(UniquenessSagaType),
+				}}
 			}
-			s.existingIds = append(s.existingIds, id)
 			return []eh.Command{&consent.MarkAsUnique{
 				ID: event.AggregateID(),
 			}}
 		}
-
+	default:
+		// Canceled, errored or otherwise ended consents free their key for new proposals,
+		// completed and rejected amendments settle the period of the key
+		if _, err := s.index.ApplyEvent(ctx, event); err != nil {
+			log.Printf("[UniquenessSaga] could not update uniqueness key: %v\n", err)
+		}
 	}
 	return nil
 }
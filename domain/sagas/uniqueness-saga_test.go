@@ -4,10 +4,14 @@ import (
 	"context"
 	"github.com/google/uuid"
 	"github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
 	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
 	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain/uniqueness"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestUniquenessSaga_RunSaga(t *testing.T) {
@@ -20,26 +24,77 @@ func TestUniquenessSaga_RunSaga(t *testing.T) {
 		Start:       consent.TimeNow(),
 	}
 
-	uniqeID := proposedData.CustodianID + proposedData.SubjectID + proposedData.ActorID
+	uniqeID := uniqueness.Key(proposedData)
+
+	otherID := uuid.New()
+	january := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	february := time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	newIndex := func(existing ...uniqueness.Period) *uniqueness.Index {
+		index := uniqueness.NewIndex(memory.NewRepo())
+		for _, period := range existing {
+			if _, err := index.Claim(context.Background(), uniqeID, period); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return index
+	}
 
 	cases := map[string]struct {
-		saga     UniquenessSaga
+		saga     *UniquenessSaga
 		event    eventhorizon.Event
 		commands []eventhorizon.Command
 	}{
 		"first time": {
-			UniquenessSaga{existingIds: make([]string, 0)},
+			NewUniquenessSaga(newIndex()),
 			eventhorizon.NewEventForAggregate(events.Proposed, proposedData, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
 			[]eventhorizon.Command{&consent.MarkAsUnique{ID: id}},
 		},
 		"duplicate": {
-			UniquenessSaga{existingIds: []string{uniqeID}},
+			NewUniquenessSaga(newIndex(uniqueness.Period{ConsentID: otherID})),
 			eventhorizon.NewEventForAggregate(events.Proposed, proposedData, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
 			[]eventhorizon.Command{&consent.Cancel{
 				ID:     id,
-				Reason: "duplicate consent",
+				Reason: "duplicate consent: overlaps with " + otherID.String(),
+				Code:   domain.ErrorCodeDuplicate,
+				Origin: string(UniquenessSagaType),
 			}},
 		},
+		"earlier period ended": {
+			NewUniquenessSaga(newIndex(uniqueness.Period{ConsentID: otherID, Start: january, End: february})),
+			eventhorizon.NewEventForAggregate(events.Proposed, proposedData, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+			[]eventhorizon.Command{&consent.MarkAsUnique{ID: id}},
+		},
+		"redelivered proposal": {
+			NewUniquenessSaga(newIndex(uniqueness.Period{ConsentID: id})),
+			eventhorizon.NewEventForAggregate(events.Proposed, proposedData, consent.TimeNow(), consent.ConsentAggregateType, id, 1),
+			[]eventhorizon.Command{&consent.MarkAsUnique{ID: id}},
+		},
+		"amendment": {
+			NewUniquenessSaga(newIndex(uniqueness.Period{ConsentID: id, Version: 1})),
+			eventhorizon.NewEventForAggregate(events.Amended, events.AmendedData{ProposedData: proposedData, Version: 2}, consent.TimeNow(), consent.ConsentAggregateType, id, 6),
+			[]eventhorizon.Command{&consent.MarkAsUnique{ID: id}},
+		},
+		"amendment overlaps other consent": {
+			NewUniquenessSaga(newIndex(uniqueness.Period{ConsentID: id, Version: 1, Start: january, End: february}, uniqueness.Period{ConsentID: otherID, Start: february})),
+			eventhorizon.NewEventForAggregate(events.Amended, events.AmendedData{ProposedData: proposedData, Version: 2}, consent.TimeNow(), consent.ConsentAggregateType, id, 6),
+			[]eventhorizon.Command{&consent.Cancel{
+				ID:     id,
+				Reason: "duplicate consent: overlaps with " + otherID.String(),
+				Code:   domain.ErrorCodeDuplicate,
+				Origin: string(UniquenessSagaType),
+			}},
+		},
+		"canceled": {
+			NewUniquenessSaga(newIndex(uniqueness.Period{ConsentID: id})),
+			eventhorizon.NewEventForAggregate(events.Canceled, nil, consent.TimeNow(), consent.ConsentAggregateType, id, 2),
+			nil,
+		},
+		"errored": {
+			NewUniquenessSaga(newIndex(uniqueness.Period{ConsentID: id})),
+			eventhorizon.NewEventForAggregate(events.Errored, nil, consent.TimeNow(), consent.ConsentAggregateType, id, 2),
+			nil,
+		},
 	}
 
 	for name, testcase := range cases {
@@ -54,3 +109,19 @@ func TestUniquenessSaga_RunSaga(t *testing.T) {
 
 	}
 }
+
+func TestUniquenessSaga_RunSaga_ReleasedKey(t *testing.T) {
+	saga := NewUniquenessSaga(uniqueness.NewIndex(memory.NewRepo()))
+	first, second := uuid.New(), uuid.New()
+	proposedData := events.ProposedData{CustodianID: "agb:123", SubjectID: "bsn:999", ActorID: "agb:456", Start: consent.TimeNow()}
+	ctx := context.Background()
+
+	saga.RunSaga(ctx, eventhorizon.NewEventForAggregate(events.Proposed, proposedData, consent.TimeNow(), consent.ConsentAggregateType, first, 1))
+	saga.RunSaga(ctx, eventhorizon.NewEventForAggregate(events.Canceled, nil, consent.TimeNow(), consent.ConsentAggregateType, first, 2))
+	commands := saga.RunSaga(ctx, eventhorizon.NewEventForAggregate(events.Proposed, proposedData, consent.TimeNow(), consent.ConsentAggregateType, second, 1))
+
+	expected := []eventhorizon.Command{&consent.MarkAsUnique{ID: second}}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("expected a new proposal to be unique after cancellation, got %#v", commands)
+	}
+}
@@ -0,0 +1,114 @@
+package sagas
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/saga"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/negotiator"
+	"log"
+	"time"
+)
+
+const WithdrawalSagaType saga.Type = "WithdrawalSaga"
+
+// DefaultWithdrawalRetry is the schedule of the attempts of the WithdrawalPropagator to inform the parties
+var DefaultWithdrawalRetry = deadline.Retry{Attempts: 8, Delay: time.Minute}
+
+// WithdrawalSaga schedules the propagation of a withdrawn consent to all parties of the negotiation.
+// The propagation is a deadline which passes immediately, the deadline.Scheduler dispatches it to the
+// WithdrawalPropagator outside the event handler.
+type WithdrawalSaga struct {
+}
+
+// MatchEvents returns the events the saga must receive
+func (s WithdrawalSaga) MatchEvents() eh.EventMatcher {
+	return eh.MatchEvent(events.Withdrawn)
+}
+
+func (s WithdrawalSaga) SagaType() saga.Type {
+	return WithdrawalSagaType
+}
+
+func (s WithdrawalSaga) RunSaga(ctx context.Context, event eh.Event) []eh.Command {
+	log.Printf("[WithdrawalSaga] event: %+v\n", event)
+
+	data, ok := event.Data().(events.WithdrawnData)
+	if !ok {
+		log.Printf("[WithdrawalSaga] unexpected event '%s'\n", event.EventType())
+		return nil
+	}
+	propagate := &PropagateWithdrawal{ID: event.AggregateID(), WithdrawnBy: data.WithdrawnBy, Reason: data.Reason, Attempt: 1}
+	return []eh.Command{&deadline.ScheduleDeadline{
+		ID:       withdrawalDeadline(propagate.ID, propagate.Attempt),
+		Deadline: event.Timestamp(),
+		Command:  propagate,
+	}}
+}
+
+// withdrawalDeadline returns the ID of the deadline of an attempt to propagate the withdrawal of a consent
+func withdrawalDeadline(consentID uuid.UUID, attempt int) uuid.UUID {
+	return deadline.ID(consentID, fmt.Sprintf("withdrawal/%d", attempt))
+}
+
+// WithdrawalPropagator informs all parties of the negotiation through the Negotiator when a consent is withdrawn.
+// When the parties could not be informed, it schedules the next attempt as deadline, until the attempts are used up.
+// The consent stays withdrawn either way.
+type WithdrawalPropagator struct {
+	NegotiationRepo eh.ReadRepo
+	Negotiator      negotiator.Negotiator
+	// CommandHandler schedules the next attempts
+	CommandHandler eh.CommandHandler
+	// Retry is the schedule of the attempts, DefaultWithdrawalRetry when not set
+	Retry deadline.Retry
+}
+
+var _ = eh.CommandHandler(&WithdrawalPropagator{})
+
+// HandleCommand implements the HandleCommand method of the eventhorizon.CommandHandler interface
+func (p *WithdrawalPropagator) HandleCommand(ctx context.Context, command eh.Command) error {
+	cmd, ok := command.(*PropagateWithdrawal)
+	if !ok {
+		return domain.ErrUnknownCommand
+	}
+
+	cause := p.propagate(ctx, cmd)
+	if cause == nil {
+		log.Printf("[WithdrawalPropagator] withdrawal of consent %s propagated\n", cmd.ID)
+		return nil
+	}
+	retry := p.Retry
+	if retry.Attempts < 1 {
+		retry = DefaultWithdrawalRetry
+	}
+	next := *cmd
+	next.Attempt++
+	scheduled, err := retry.Schedule(ctx, p.CommandHandler, withdrawalDeadline(next.ID, next.Attempt), cmd.Attempt, &next)
+	if err != nil {
+		return err
+	}
+	if !scheduled {
+		log.Printf("[WithdrawalPropagator] could not propagate the withdrawal of consent %s, giving up after %d attempts: %v\n", cmd.ID, cmd.Attempt, cause)
+		return nil
+	}
+	log.Printf("[WithdrawalPropagator] attempt %d of %d to propagate the withdrawal of consent %s failed: %v\n", cmd.Attempt, retry.Attempts, cmd.ID, cause)
+	return nil
+}
+
+// propagate informs the parties of the negotiation of the consent
+func (p *WithdrawalPropagator) propagate(ctx context.Context, cmd *PropagateWithdrawal) error {
+	entity, err := p.NegotiationRepo.Find(ctx, cmd.ID)
+	if err != nil {
+		return fmt.Errorf("could not load the consent: %w", err)
+	}
+	negotiation, ok := entity.(*consent.ConsentNegotiation)
+	if !ok {
+		return errWrongEntityType
+	}
+	return p.Negotiator.Withdraw(negotiation.ID, negotiation.SyncID, negotiation.Parties, cmd.WithdrawnBy, cmd.Reason)
+}
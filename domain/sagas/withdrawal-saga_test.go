@@ -0,0 +1,131 @@
+package sagas
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// commandRecorder records the commands it handles and returns err
+type commandRecorder struct {
+	commands []eh.Command
+	err      error
+}
+
+func (c *commandRecorder) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	c.commands = append(c.commands, cmd)
+	return c.err
+}
+
+func TestWithdrawalSaga_RunSaga(t *testing.T) {
+	id := uuid.New()
+	at := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	event := eh.NewEventForAggregate(events.Withdrawn, events.WithdrawnData{WithdrawnBy: "bsn:999999990", Reason: "changed my mind"}, at, consent.ConsentAggregateType, id, 6)
+
+	commands := WithdrawalSaga{}.RunSaga(context.Background(), event)
+	expected := []eh.Command{&deadline.ScheduleDeadline{
+		ID:       withdrawalDeadline(id, 1),
+		Deadline: at,
+		Command:  &PropagateWithdrawal{ID: id, WithdrawnBy: "bsn:999999990", Reason: "changed my mind", Attempt: 1},
+	}}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("incorrect commands")
+		t.Logf("exp: %#v", expected)
+		t.Logf("got: %#v", commands)
+	}
+}
+
+func TestWithdrawalPropagator_HandleCommand(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	deadline.TimeNow = func() time.Time {
+		return now
+	}
+	defer func() {
+		deadline.TimeNow = time.Now
+	}()
+
+	id := uuid.New()
+	negotiationRepo := func() eh.ReadWriteRepo {
+		repo := memory.NewRepo()
+		if err := repo.Save(context.Background(), &consent.ConsentNegotiation{ID: id, SyncID: uuid.New(), Version: 6}); err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+	retry := func(attempt int, delay time.Duration) []eh.Command {
+		return []eh.Command{&deadline.ScheduleDeadline{
+			ID:       withdrawalDeadline(id, attempt),
+			Deadline: now.Add(delay),
+			Command:  &PropagateWithdrawal{ID: id, WithdrawnBy: "bsn:999999990", Attempt: attempt},
+		}}
+	}
+
+	cases := map[string]struct {
+		repo          eh.ReadWriteRepo
+		negotiator    *failingNegotiator
+		attempt       int
+		scheduleErr   error
+		expectedCalls int
+		expected      []eh.Command
+		expectedErr   bool
+	}{
+		"parties informed": {
+			negotiationRepo(), &failingNegotiator{}, 1, nil,
+			1, nil, false,
+		},
+		"first attempt fails": {
+			negotiationRepo(), &failingNegotiator{Failures: 1}, 1, nil,
+			1, retry(2, time.Minute), false,
+		},
+		"third attempt fails": {
+			negotiationRepo(), &failingNegotiator{Failures: 1}, 3, nil,
+			1, retry(4, 4*time.Minute), false,
+		},
+		"last attempt fails": {
+			negotiationRepo(), &failingNegotiator{Failures: 1}, DefaultWithdrawalRetry.Attempts, nil,
+			1, nil, false,
+		},
+		"repo fails": {
+			&mocks.Repo{LoadErr: errors.New("connection lost")}, &failingNegotiator{}, 1, nil,
+			0, retry(2, time.Minute), false,
+		},
+		"next attempt already scheduled": {
+			negotiationRepo(), &failingNegotiator{Failures: 1}, 1, domain.InvalidTransitionError{State: "scheduled", Command: string(deadline.ScheduleDeadlineCmdType)},
+			1, retry(2, time.Minute), false,
+		},
+		"next attempt not scheduled": {
+			negotiationRepo(), &failingNegotiator{Failures: 1}, 1, errors.New("version conflict"),
+			1, retry(2, time.Minute), true,
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			recorder := &commandRecorder{err: testcase.scheduleErr}
+			propagator := &WithdrawalPropagator{NegotiationRepo: testcase.repo, Negotiator: testcase.negotiator, CommandHandler: recorder}
+
+			err := propagator.HandleCommand(context.Background(), &PropagateWithdrawal{ID: id, WithdrawnBy: "bsn:999999990", Attempt: testcase.attempt})
+			if (err != nil) != testcase.expectedErr {
+				t.Errorf("unexpected error result: %v", err)
+			}
+			if testcase.negotiator.calls != testcase.expectedCalls {
+				t.Errorf("expected %d negotiator calls, got %d", testcase.expectedCalls, testcase.negotiator.calls)
+			}
+			if !reflect.DeepEqual(recorder.commands, testcase.expected) {
+				t.Errorf("incorrect commands")
+				t.Logf("exp: %#v", testcase.expected)
+				t.Logf("got: %#v", recorder.commands)
+			}
+		})
+	}
+}
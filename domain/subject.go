@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// BSNScheme is the short scheme for Dutch citizen service numbers (BSN)
+const BSNScheme = "bsn"
+
+// BSNOID is the URN of the OID for Dutch citizen service numbers (BSN)
+const BSNOID = "urn:oid:2.16.840.1.113883.2.4.6.3"
+
+var ErrUnsupportedSubjectScheme = errors.New("unsupported subject identifier scheme")
+var ErrInvalidBSN = errors.New("invalid BSN")
+
+// ValidateSubjectID checks that the identifier uses a supported scheme and has a valid value.
+// Supported are "bsn:<BSN>" and "urn:oid:2.16.840.1.113883.2.4.6.3:<BSN>".
+func ValidateSubjectID(id string) error {
+	id = NormaliseIdentifier(id)
+	i := strings.LastIndex(id, ":")
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrUnsupportedSubjectScheme, id)
+	}
+
+	switch scheme := id[:i]; scheme {
+	case BSNScheme, BSNOID:
+		return ValidateBSN(id[i+1:])
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedSubjectScheme, scheme)
+	}
+}
+
+// ValidateBSN checks the BSN consists of 9 digits and passes the elfproef:
+// 9*d1 + 8*d2 + 7*d3 + 6*d4 + 5*d5 + 4*d6 + 3*d7 + 2*d8 - d9 must be a multiple of 11.
+func ValidateBSN(bsn string) error {
+	if len(bsn) != 9 {
+		return fmt.Errorf("%w: must consist of 9 digits", ErrInvalidBSN)
+	}
+	sum := 0
+	for i, c := range bsn {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("%w: must consist of 9 digits", ErrInvalidBSN)
+		}
+		weight := 9 - i
+		if i == 8 {
+			weight = -1
+		}
+		sum += weight * int(c-'0')
+	}
+	if sum == 0 || sum%11 != 0 {
+		return fmt.Errorf("%w: checksum failed", ErrInvalidBSN)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateSubjectID(t *testing.T) {
+	cases := map[string]error{
+		"bsn:999999990": nil,
+		"BSN:111222333": nil,
+		"urn:oid:2.16.840.1.113883.2.4.6.3:999999990": nil,
+		"URN:OID:2.16.840.1.113883.2.4.6.3:123456782": nil,
+		"bsn:999999991": ErrInvalidBSN,
+		"bsn:999":       ErrInvalidBSN,
+		"bsn:99999999a": ErrInvalidBSN,
+		"bsn:000000000": ErrInvalidBSN,
+		"urn:oid:2.16.840.1.113883.2.4.6.1:123456782": ErrUnsupportedSubjectScheme,
+		"agb:123":   ErrUnsupportedSubjectScheme,
+		"999999990": ErrUnsupportedSubjectScheme,
+	}
+
+	for id, expected := range cases {
+		t.Run(id, func(t *testing.T) {
+			err := ValidateSubjectID(id)
+			if (expected == nil && err != nil) || (expected != nil && !errors.Is(err, expected)) {
+				t.Errorf("expected %v, got %v", expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package domain
+
+import (
+	eh "github.com/looplab/eventhorizon"
+)
+
+// State is the state of an aggregate. The aggregates declare their state type as an alias of State,
+// so they can list their transitions as Transitions.
+type State string
+
+// Transitions lists per command the states in which an aggregate accepts it.
+// The resulting state is set by the ApplyEvent of the aggregate.
+type Transitions map[eh.CommandType][]State
+
+// Check returns ErrUnknownCommand when the command is not listed and an InvalidTransitionError when the command
+// is not allowed in the given state
+func (t Transitions) Check(state State, commandType eh.CommandType) error {
+	states, ok := t[commandType]
+	if !ok {
+		return ErrUnknownCommand
+	}
+	for _, allowed := range states {
+		if state == allowed {
+			return nil
+		}
+	}
+	return InvalidTransitionError{State: string(state), Command: string(commandType)}
+}
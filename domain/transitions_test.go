@@ -0,0 +1,32 @@
+package domain
+
+import (
+	eh "github.com/looplab/eventhorizon"
+	"reflect"
+	"testing"
+)
+
+func TestTransitions_Check(t *testing.T) {
+	transitions := Transitions{
+		eh.CommandType("start"):  {State("")},
+		eh.CommandType("finish"): {State("started"), State("paused")},
+	}
+	cases := map[string]struct {
+		state       State
+		commandType eh.CommandType
+		expected    error
+	}{
+		"allowed":         {State("paused"), "finish", nil},
+		"allowed in new":  {State(""), "start", nil},
+		"not allowed":     {State("finished"), "finish", InvalidTransitionError{State: "finished", Command: "finish"}},
+		"unknown command": {State("started"), "pause", ErrUnknownCommand},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			if err := transitions.Check(testcase.state, testcase.commandType); !reflect.DeepEqual(err, testcase.expected) {
+				t.Errorf("expected '%v', got '%v'", testcase.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package uniqueness
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"sync"
+	"time"
+)
+
+// keyNamespace and consentNamespace are used to derive the IDs of the index entities,
+// so keys and consents can live in the same repository without colliding.
+var keyNamespace = uuid.MustParse("6e0ba7a6-5f4c-4c1b-8a6c-6f0cbd1e3c51")
+var consentNamespace = uuid.MustParse("0b9e4c5c-0d2a-4b4e-9a7e-2f2d0e6a7f3b")
+
+// Period is the validity period of a consent. A zero End means the consent is valid indefinitely.
+type Period struct {
+	ConsentID uuid.UUID
+	// Version is the version of the terms of the consent the period belongs to
+	Version int
+	Start   time.Time
+	End     time.Time
+	// Class and PurposeOfUse are the terms the period covers. Consents proposed before they were required have
+	// empty values, such a consent covers every class and purpose of use.
+	Class        string
+	PurposeOfUse string
+}
+
+// Overlaps returns true when both periods share at least one moment. End is exclusive.
+func (p Period) Overlaps(other Period) bool {
+	return (p.End.IsZero() || other.Start.Before(p.End)) &&
+		(other.End.IsZero() || p.Start.Before(other.End))
+}
+
+// Conflicts returns true when both periods overlap and cover the same class and purpose of use
+func (p Period) Conflicts(other Period) bool {
+	return p.Overlaps(other) && covers(p.Class, other.Class) && covers(p.PurposeOfUse, other.PurposeOfUse)
+}
+
+// covers returns true when the codes are equal or one of them is empty, which covers every code
+func covers(code, other string) bool {
+	return code == "" || other == "" || code == other
+}
+
+// KeyEntry maps the hash of a uniqueness key to the periods of the consents for that key
+type KeyEntry struct {
+	ID      uuid.UUID
+	Key     string
+	Periods []Period
+}
+
+func (e KeyEntry) EntityID() uuid.UUID {
+	return e.ID
+}
+
+// ConsentEntry maps a consent to the key it owns, so the key can be released by consent ID.
+// While an amendment is in progress, the consent also owns the key of the amended version.
+type ConsentEntry struct {
+	ID        uuid.UUID
+	ConsentID uuid.UUID
+	KeyID     uuid.UUID
+	// AmendmentKeyID and AmendmentVersion identify the period claimed by an amendment in progress
+	AmendmentKeyID   uuid.UUID
+	AmendmentVersion int
+}
+
+func (e ConsentEntry) EntityID() uuid.UUID {
+	return e.ID
+}
+
+var _ = eh.Entity(&KeyEntry{})
+var _ = eh.Entity(&ConsentEntry{})
+
+// ReleasingEvents are the events which end the life of a consent and free its uniqueness key
+var ReleasingEvents = []eh.EventType{events.Canceled, events.Errored, events.Expired, events.Withdrawn}
+
+// AmendingEvents are the events which claim, commit or revert the period of an amendment
+var AmendingEvents = []eh.EventType{events.Amended, events.Completed, events.AmendmentRejected}
+
+// Key returns the uniqueness key for a proposed consent, consents for the same parties share the key.
+// They only conflict when their periods do, see Period.Conflicts.
+func Key(data events.ProposedData) string {
+	return domain.NewPartyKey(data.CustodianID, data.SubjectID, data.ActorID).String()
+}
+
+// NewPeriod returns the period of a version of the terms of a consent
+func NewPeriod(consentID uuid.UUID, version int, data events.ProposedData) Period {
+	return Period{
+		ConsentID:    consentID,
+		Version:      version,
+		Start:        data.Start,
+		End:          data.End,
+		Class:        data.Class,
+		PurposeOfUse: data.PurposeOfUse,
+	}
+}
+
+// Index keeps track of which consents own which uniqueness key and for which period.
+// Lookups are done by the hash of the key, so checking for duplicates does not depend on the number of consents.
+// The index is not persisted: it is derived from the consent events only, so it is rebuilt from the event store on
+// startup. The event store is the single source of truth and the index can never disagree with it.
+type Index struct {
+	repo eh.ReadWriteRepo
+	// mu makes the lookup and store in Claim, Amend, Commit, Revert and Release atomic
+	mu sync.Mutex
+}
+
+// NewIndex creates an Index storing its entries in repo
+func NewIndex(repo eh.ReadWriteRepo) *Index {
+	return &Index{repo: repo}
+}
+
+// Claim registers the key for the period when no other consent holds the key for a conflicting period.
+// It returns the ID of the consent holding the key for the period: when it differs from period.ConsentID,
+// the claim conflicts with that consent.
+func (i *Index) Claim(ctx context.Context, key string, period Period) (uuid.UUID, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	keyID := uuid.NewSHA1(keyNamespace, []byte(key))
+	entry := &KeyEntry{ID: keyID, Key: key}
+	entity, err := i.repo.Find(ctx, keyID)
+	if err == nil {
+		entry = entity.(*KeyEntry)
+	} else if !domain.IsNotFound(err) {
+		return uuid.Nil, err
+	}
+
+	for _, existing := range entry.Periods {
+		// a redelivered proposal finds its own claim
+		if existing.ConsentID == period.ConsentID || existing.Conflicts(period) {
+			return existing.ConsentID, nil
+		}
+	}
+
+	entry.Periods = append(entry.Periods, period)
+	if err := i.repo.Save(ctx, entry); err != nil {
+		return uuid.Nil, err
+	}
+	if err := i.repo.Save(ctx, &ConsentEntry{ID: consentEntryID(period.ConsentID), ConsentID: period.ConsentID, KeyID: keyID}); err != nil {
+		return uuid.Nil, err
+	}
+	return period.ConsentID, nil
+}
+
+// Amend claims the key for the period of a new version of a consent, next to the period of the version in force.
+// Like Claim, it returns the ID of the consent holding the key for the period. The claim is settled by Commit or Revert.
+func (i *Index) Amend(ctx context.Context, key string, period Period) (uuid.UUID, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	keyID := uuid.NewSHA1(keyNamespace, []byte(key))
+	entry := &KeyEntry{ID: keyID, Key: key}
+	entity, err := i.repo.Find(ctx, keyID)
+	if err == nil {
+		entry = entity.(*KeyEntry)
+	} else if !domain.IsNotFound(err) {
+		return uuid.Nil, err
+	}
+
+	for _, existing := range entry.Periods {
+		if existing.ConsentID == period.ConsentID {
+			// a redelivered amendment finds its own claim, the other versions of the consent do not conflict
+			if existing.Version == period.Version {
+				return existing.ConsentID, nil
+			}
+			continue
+		}
+		if existing.Conflicts(period) {
+			return existing.ConsentID, nil
+		}
+	}
+
+	consentEntry, err := i.findConsentEntry(ctx, period.ConsentID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	entry.Periods = append(entry.Periods, period)
+	if err := i.repo.Save(ctx, entry); err != nil {
+		return uuid.Nil, err
+	}
+	consentEntry.AmendmentKeyID = keyID
+	consentEntry.AmendmentVersion = period.Version
+	if err := i.repo.Save(ctx, consentEntry); err != nil {
+		return uuid.Nil, err
+	}
+	return period.ConsentID, nil
+}
+
+// Commit makes the period of the amendment of the consent the one in force and frees the period of the previous version.
+// Committing a consent without an amendment in progress is a no-op.
+func (i *Index) Commit(ctx context.Context, consentID uuid.UUID) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entry, err := i.findConsentEntry(ctx, consentID)
+	if err != nil || entry.AmendmentKeyID == uuid.Nil {
+		return err
+	}
+	version := entry.AmendmentVersion
+	if err := i.removePeriods(ctx, entry.KeyID, func(period Period) bool {
+		return period.ConsentID == consentID && period.Version != version
+	}); err != nil {
+		return err
+	}
+	entry.KeyID = entry.AmendmentKeyID
+	entry.AmendmentKeyID = uuid.Nil
+	entry.AmendmentVersion = 0
+	return i.repo.Save(ctx, entry)
+}
+
+// Revert frees the period claimed by the amendment of the consent, the period of the version in force is kept.
+// Reverting a consent without an amendment in progress is a no-op.
+func (i *Index) Revert(ctx context.Context, consentID uuid.UUID) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entry, err := i.findConsentEntry(ctx, consentID)
+	if err != nil || entry.AmendmentKeyID == uuid.Nil {
+		return err
+	}
+	version := entry.AmendmentVersion
+	if err := i.removePeriods(ctx, entry.AmendmentKeyID, func(period Period) bool {
+		return period.ConsentID == consentID && period.Version == version
+	}); err != nil {
+		return err
+	}
+	entry.AmendmentKeyID = uuid.Nil
+	entry.AmendmentVersion = 0
+	return i.repo.Save(ctx, entry)
+}
+
+// Release frees the periods claimed by the consent. Releasing a consent without a claim is a no-op.
+func (i *Index) Release(ctx context.Context, consentID uuid.UUID) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entity, err := i.repo.Find(ctx, consentEntryID(consentID))
+	if domain.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entry := entity.(*ConsentEntry)
+	ownedBy := func(period Period) bool {
+		return period.ConsentID == consentID
+	}
+	if err := i.removePeriods(ctx, entry.KeyID, ownedBy); err != nil {
+		return err
+	}
+	if entry.AmendmentKeyID != uuid.Nil && entry.AmendmentKeyID != entry.KeyID {
+		if err := i.removePeriods(ctx, entry.AmendmentKeyID, ownedBy); err != nil {
+			return err
+		}
+	}
+	return i.repo.Remove(ctx, entry.ID)
+}
+
+// findConsentEntry returns the entry of the consent, or a new one without a key when the consent has no claim
+func (i *Index) findConsentEntry(ctx context.Context, consentID uuid.UUID) (*ConsentEntry, error) {
+	entity, err := i.repo.Find(ctx, consentEntryID(consentID))
+	if domain.IsNotFound(err) {
+		return &ConsentEntry{ID: consentEntryID(consentID), ConsentID: consentID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entity.(*ConsentEntry), nil
+}
+
+// removePeriods removes the periods matching remove from the key, the key is removed when no periods are left
+func (i *Index) removePeriods(ctx context.Context, keyID uuid.UUID, remove func(Period) bool) error {
+	entity, err := i.repo.Find(ctx, keyID)
+	if domain.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entry := entity.(*KeyEntry)
+	periods := make([]Period, 0, len(entry.Periods))
+	for _, period := range entry.Periods {
+		if !remove(period) {
+			periods = append(periods, period)
+		}
+	}
+	entry.Periods = periods
+
+	if len(periods) == 0 {
+		return i.repo.Remove(ctx, keyID)
+	}
+	return i.repo.Save(ctx, entry)
+}
+
+// ApplyEvent updates the index for an event. It's used by the UniquenessSaga and for rebuilding the index.
+// It returns the consent holding the key for the proposed period when a consent is proposed or amended.
+func (i *Index) ApplyEvent(ctx context.Context, event eh.Event) (uuid.UUID, error) {
+	switch event.EventType() {
+	case events.Proposed:
+		data, ok := event.Data().(events.ProposedData)
+		if !ok {
+			return uuid.Nil, nil
+		}
+		return i.Claim(ctx, Key(data), NewPeriod(event.AggregateID(), 1, data))
+	case events.Amended:
+		data, ok := event.Data().(events.AmendedData)
+		if !ok {
+			return uuid.Nil, nil
+		}
+		return i.Amend(ctx, Key(data.ProposedData), NewPeriod(event.AggregateID(), data.Version, data.ProposedData))
+	case events.Completed:
+		return uuid.Nil, i.Commit(ctx, event.AggregateID())
+	case events.AmendmentRejected:
+		return uuid.Nil, i.Revert(ctx, event.AggregateID())
+	}
+	if releases(event.EventType()) {
+		return uuid.Nil, i.Release(ctx, event.AggregateID())
+	}
+	return uuid.Nil, nil
+}
+
+// Rebuild clears the index and replays the given events into it
+func (i *Index) Rebuild(ctx context.Context, history []eh.Event) error {
+	return domain.Rebuild(ctx, i.repo, history, func(ctx context.Context, event eh.Event) error {
+		_, err := i.ApplyEvent(ctx, event)
+		return err
+	})
+}
+
+func releases(eventType eh.EventType) bool {
+	for _, releasing := range ReleasingEvents {
+		if eventType == releasing {
+			return true
+		}
+	}
+	return false
+}
+
+func consentEntryID(consentID uuid.UUID) uuid.UUID {
+	return uuid.NewSHA1(consentNamespace, consentID[:])
+}
@@ -0,0 +1,386 @@
+package uniqueness
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/eventstore/bolt/bolttest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func proposedEvent(id uuid.UUID, custodian, subject, actor string) eh.Event {
+	return eh.NewEventForAggregate(events.Proposed, events.ProposedData{
+		ID:          id,
+		CustodianID: custodian,
+		SubjectID:   subject,
+		ActorID:     actor,
+		Start:       time.Now(),
+	}, time.Now(), eh.AggregateType("consent"), id, 1)
+}
+
+func TestIndex_Claim(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(memory.NewRepo())
+	first, second := uuid.New(), uuid.New()
+
+	owner, err := index.Claim(ctx, "key", Period{ConsentID: first})
+	if err != nil || owner != first {
+		t.Fatalf("expected first claim to succeed, got %s, %v", owner, err)
+	}
+	owner, err = index.Claim(ctx, "key", Period{ConsentID: second})
+	if err != nil || owner != first {
+		t.Errorf("expected key to be owned by first consent, got %s, %v", owner, err)
+	}
+
+	t.Run("release by non-owner keeps the key", func(t *testing.T) {
+		if err := index.Release(ctx, second); err != nil {
+			t.Fatal(err)
+		}
+		if owner, _ := index.Claim(ctx, "key", Period{ConsentID: second}); owner != first {
+			t.Errorf("expected key to still be owned by first consent, got %s", owner)
+		}
+	})
+
+	t.Run("release by owner frees the key", func(t *testing.T) {
+		if err := index.Release(ctx, first); err != nil {
+			t.Fatal(err)
+		}
+		if owner, _ := index.Claim(ctx, "key", Period{ConsentID: second}); owner != second {
+			t.Errorf("expected key to be claimed by second consent, got %s", owner)
+		}
+	})
+}
+
+func TestIndex_Claim_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(memory.NewRepo())
+
+	const claimants = 20
+	owners := make(chan uuid.UUID, claimants)
+	wg := sync.WaitGroup{}
+	for i := 0; i < claimants; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := uuid.New()
+			owner, err := index.Claim(ctx, "key", Period{ConsentID: id})
+			if err != nil {
+				t.Error(err)
+			}
+			if owner == id {
+				owners <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(owners)
+
+	if len(owners) != 1 {
+		t.Errorf("expected exactly one successful claim, got %d", len(owners))
+	}
+}
+
+func TestIndex_Rebuild(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(memory.NewRepo())
+	original, duplicate, canceled, stale := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	if _, err := index.Claim(ctx, "stale key", Period{ConsentID: stale}); err != nil {
+		t.Fatal(err)
+	}
+
+	history := []eh.Event{
+		proposedEvent(original, "agb:123", "bsn:999", "agb:456"),
+		proposedEvent(duplicate, "agb:123", "bsn:999", "agb:456"),
+		eh.NewEventForAggregate(events.Canceled, nil, time.Now(), eh.AggregateType("consent"), duplicate, 2),
+		proposedEvent(canceled, "agb:123", "bsn:888", "agb:456"),
+		eh.NewEventForAggregate(events.Canceled, nil, time.Now(), eh.AggregateType("consent"), canceled, 2),
+	}
+	if err := index.Rebuild(ctx, history); err != nil {
+		t.Fatal(err)
+	}
+
+	next := uuid.New()
+	if owner, _ := index.Claim(ctx, Key(events.ProposedData{CustodianID: "agb:123", SubjectID: "bsn:999", ActorID: "agb:456"}), Period{ConsentID: next}); owner != original {
+		t.Errorf("expected original consent to own its key, got %s", owner)
+	}
+	if owner, _ := index.Claim(ctx, Key(events.ProposedData{CustodianID: "agb:123", SubjectID: "bsn:888", ActorID: "agb:456"}), Period{ConsentID: next}); owner != next {
+		t.Errorf("expected key of canceled consent to be free, got %s", owner)
+	}
+	if owner, _ := index.Claim(ctx, "stale key", Period{ConsentID: next}); owner != next {
+		t.Errorf("expected stale key to be removed, got %s", owner)
+	}
+}
+
+func TestIndex_Restart(t *testing.T) {
+	ctx := context.Background()
+	original, canceled := uuid.New(), uuid.New()
+	history := bolttest.Restart(t, []eh.Event{
+		proposedEvent(original, "agb:123", "bsn:999", "agb:456"),
+		proposedEvent(canceled, "agb:123", "bsn:888", "agb:456"),
+		eh.NewEventForAggregate(events.Canceled, nil, time.Now(), eh.AggregateType("consent"), canceled, 2),
+	})
+
+	// the service builds a new index from the events in the store
+	index := NewIndex(memory.NewRepo())
+	if err := index.Rebuild(ctx, history); err != nil {
+		t.Fatal(err)
+	}
+
+	duplicate := uuid.New()
+	if owner, err := index.ApplyEvent(ctx, proposedEvent(duplicate, "agb:123", "bsn:999", "agb:456")); err != nil || owner != original {
+		t.Errorf("expected the duplicate to be rejected in favour of %s, got %s, %v", original, owner, err)
+	}
+	next := uuid.New()
+	if owner, err := index.ApplyEvent(ctx, proposedEvent(next, "agb:123", "bsn:888", "agb:456")); err != nil || owner != next {
+		t.Errorf("expected the key of the canceled consent to be free, got %s, %v", owner, err)
+	}
+}
+
+func TestIndex_ApplyEvent_Releasing(t *testing.T) {
+	for _, eventType := range ReleasingEvents {
+		t.Run(string(eventType), func(t *testing.T) {
+			ctx := context.Background()
+			index := NewIndex(memory.NewRepo())
+			first, second := uuid.New(), uuid.New()
+
+			if _, err := index.ApplyEvent(ctx, proposedEvent(first, "agb:123", "bsn:999", "agb:456")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := index.ApplyEvent(ctx, eh.NewEventForAggregate(eventType, nil, time.Now(), eh.AggregateType("consent"), first, 2)); err != nil {
+				t.Fatal(err)
+			}
+			owner, err := index.ApplyEvent(ctx, proposedEvent(second, "agb:123", "bsn:999", "agb:456"))
+			if err != nil || owner != second {
+				t.Errorf("expected new proposal to own the released key, got %s, %v", owner, err)
+			}
+		})
+	}
+}
+
+func TestPeriod_Overlaps(t *testing.T) {
+	month := func(m time.Month) time.Time {
+		return time.Date(2020, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+	cases := map[string]struct {
+		a, b     Period
+		expected bool
+	}{
+		"january and july":  {Period{Start: month(1), End: month(2)}, Period{Start: month(7), End: month(8)}, false},
+		"adjacent periods":  {Period{Start: month(1), End: month(2)}, Period{Start: month(2), End: month(3)}, false},
+		"partial overlap":   {Period{Start: month(1), End: month(3)}, Period{Start: month(2), End: month(4)}, true},
+		"contained":         {Period{Start: month(1), End: month(6)}, Period{Start: month(2), End: month(3)}, true},
+		"open ended before": {Period{Start: month(1)}, Period{Start: month(7), End: month(8)}, true},
+		"open ended after":  {Period{Start: month(7)}, Period{Start: month(1), End: month(2)}, false},
+		"both open ended":   {Period{Start: month(7)}, Period{Start: month(1)}, true},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			if testcase.a.Overlaps(testcase.b) != testcase.expected || testcase.b.Overlaps(testcase.a) != testcase.expected {
+				t.Errorf("expected overlap to be %v", testcase.expected)
+			}
+		})
+	}
+}
+
+func TestIndex_Claim_Periods(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(memory.NewRepo())
+	january := Period{ConsentID: uuid.New(), Start: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)}
+	july := Period{ConsentID: uuid.New(), Start: time.Date(2020, time.July, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2020, time.August, 1, 0, 0, 0, 0, time.UTC)}
+	year := Period{ConsentID: uuid.New(), Start: january.Start}
+
+	for _, period := range []Period{january, july} {
+		if owner, err := index.Claim(ctx, "key", period); err != nil || owner != period.ConsentID {
+			t.Fatalf("expected non-overlapping claim to succeed, got %s, %v", owner, err)
+		}
+	}
+	if owner, _ := index.Claim(ctx, "key", year); owner != january.ConsentID {
+		t.Errorf("expected overlapping claim to conflict with january, got %s", owner)
+	}
+
+	if err := index.Release(ctx, january.ConsentID); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _ := index.Claim(ctx, "key", year); owner != july.ConsentID {
+		t.Errorf("expected overlapping claim to conflict with july, got %s", owner)
+	}
+}
+
+func TestIndex_ApplyEvent_NoCollisions(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(memory.NewRepo())
+	first, second := uuid.New(), uuid.New()
+
+	if _, err := index.ApplyEvent(ctx, proposedEvent(first, "agb:1", "23bsn:9", "agb:456")); err != nil {
+		t.Fatal(err)
+	}
+	owner, err := index.ApplyEvent(ctx, proposedEvent(second, "agb:12", "3bsn:9", "agb:456"))
+	if err != nil || owner != second {
+		t.Errorf("expected different parties not to collide, got %s, %v", owner, err)
+	}
+}
+
+func TestIndex_Amend(t *testing.T) {
+	ctx := context.Background()
+	month := func(m time.Month) time.Time {
+		return time.Date(2020, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+	consentID := uuid.New()
+	proposed := Period{ConsentID: consentID, Version: 1, Start: month(time.January), End: month(time.April)}
+	shortened := Period{ConsentID: consentID, Version: 2, Start: month(time.January), End: month(time.February)}
+	extended := Period{ConsentID: consentID, Version: 2, Start: month(time.January), End: month(time.July)}
+	march := Period{ConsentID: uuid.New(), Start: month(time.March), End: month(time.April)}
+	may := Period{ConsentID: uuid.New(), Start: month(time.May), End: month(time.June)}
+
+	newIndex := func(t *testing.T) *Index {
+		index := NewIndex(memory.NewRepo())
+		if _, err := index.Claim(ctx, "key", proposed); err != nil {
+			t.Fatal(err)
+		}
+		return index
+	}
+
+	t.Run("previous period is kept until the amendment is committed", func(t *testing.T) {
+		index := newIndex(t)
+		if owner, err := index.Amend(ctx, "key", shortened); err != nil || owner != consentID {
+			t.Fatalf("expected amendment to succeed, got %s, %v", owner, err)
+		}
+		if owner, _ := index.Claim(ctx, "key", march); owner != consentID {
+			t.Errorf("expected previous period to be claimed during the amendment, got %s", owner)
+		}
+		if err := index.Commit(ctx, consentID); err != nil {
+			t.Fatal(err)
+		}
+		if owner, _ := index.Claim(ctx, "key", march); owner != march.ConsentID {
+			t.Errorf("expected previous period to be free after commit, got %s", owner)
+		}
+	})
+
+	t.Run("amended period is freed when the amendment is reverted", func(t *testing.T) {
+		index := newIndex(t)
+		if owner, err := index.Amend(ctx, "key", extended); err != nil || owner != consentID {
+			t.Fatalf("expected amendment to succeed, got %s, %v", owner, err)
+		}
+		if owner, _ := index.Claim(ctx, "key", may); owner != consentID {
+			t.Errorf("expected amended period to be claimed, got %s", owner)
+		}
+		if err := index.Revert(ctx, consentID); err != nil {
+			t.Fatal(err)
+		}
+		if owner, _ := index.Claim(ctx, "key", may); owner != may.ConsentID {
+			t.Errorf("expected amended period to be free after revert, got %s", owner)
+		}
+		if owner, _ := index.Claim(ctx, "key", march); owner != consentID {
+			t.Errorf("expected previous period to still be claimed, got %s", owner)
+		}
+	})
+
+	t.Run("amendment overlapping another consent conflicts", func(t *testing.T) {
+		index := newIndex(t)
+		if _, err := index.Claim(ctx, "key", may); err != nil {
+			t.Fatal(err)
+		}
+		if owner, _ := index.Amend(ctx, "key", extended); owner != may.ConsentID {
+			t.Errorf("expected amendment to conflict with may, got %s", owner)
+		}
+	})
+
+	t.Run("release frees the periods of all versions", func(t *testing.T) {
+		index := newIndex(t)
+		if _, err := index.Amend(ctx, "other key", extended); err != nil {
+			t.Fatal(err)
+		}
+		if err := index.Release(ctx, consentID); err != nil {
+			t.Fatal(err)
+		}
+		if owner, _ := index.Claim(ctx, "key", march); owner != march.ConsentID {
+			t.Errorf("expected previous period to be free, got %s", owner)
+		}
+		if owner, _ := index.Claim(ctx, "other key", may); owner != may.ConsentID {
+			t.Errorf("expected amended period to be free, got %s", owner)
+		}
+	})
+}
+
+func TestKey(t *testing.T) {
+	medication := events.ProposedData{CustodianID: "agb:123", SubjectID: "bsn:999", ActorID: "agb:456", Class: "medication", PurposeOfUse: "TREAT"}
+	labResults := medication
+	labResults.Class = "lab-results"
+	normalised := medication
+	normalised.CustodianID = " AGB:123"
+	otherActor := medication
+	otherActor.ActorID = "agb:789"
+
+	if Key(medication) != Key(labResults) {
+		t.Error("expected consents for the same parties to share the key")
+	}
+	if Key(medication) != Key(normalised) {
+		t.Error("expected the identifiers to be normalised")
+	}
+	if Key(medication) == Key(otherActor) {
+		t.Error("expected consents for other parties not to share the key")
+	}
+}
+
+func TestPeriod_Conflicts(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	terms := func(class, purposeOfUse string) Period {
+		return Period{Start: start, Class: class, PurposeOfUse: purposeOfUse}
+	}
+	cases := map[string]struct {
+		a, b     Period
+		expected bool
+	}{
+		"same terms":             {terms("medication", "TREAT"), terms("medication", "TREAT"), true},
+		"other class":            {terms("medication", "TREAT"), terms("lab-results", "TREAT"), false},
+		"other purpose of use":   {terms("medication", "TREAT"), terms("medication", "HRESCH"), false},
+		"legacy consent":         {terms("", ""), terms("medication", "TREAT"), true},
+		"legacy class":           {terms("", "TREAT"), terms("lab-results", "TREAT"), true},
+		"legacy purpose of use":  {terms("medication", ""), terms("medication", "HRESCH"), true},
+		"both legacy":            {terms("", ""), terms("", ""), true},
+		"legacy, later period":   {Period{Start: start, End: start.AddDate(0, 1, 0)}, Period{Start: start.AddDate(0, 2, 0), Class: "medication", PurposeOfUse: "TREAT"}, false},
+		"legacy, other purposes": {terms("", "TREAT"), terms("medication", "HRESCH"), false},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			if testcase.a.Conflicts(testcase.b) != testcase.expected || testcase.b.Conflicts(testcase.a) != testcase.expected {
+				t.Errorf("expected conflict to be %v", testcase.expected)
+			}
+		})
+	}
+}
+
+func TestIndex_ApplyEvent_LegacyTerms(t *testing.T) {
+	ctx := context.Background()
+	index := NewIndex(memory.NewRepo())
+	legacy, medication, labResults := uuid.New(), uuid.New(), uuid.New()
+	proposed := func(id uuid.UUID, class, purposeOfUse string) eh.Event {
+		data := events.ProposedData{ID: id, CustodianID: "agb:123", SubjectID: "bsn:999", ActorID: "agb:456", Class: class, PurposeOfUse: purposeOfUse, Start: time.Now()}
+		return eh.NewEventForAggregate(events.Proposed, data, time.Now(), eh.AggregateType("consent"), id, 1)
+	}
+
+	// a consent stored before the class and purpose of use were required
+	if err := index.Rebuild(ctx, []eh.Event{proposed(legacy, "", "")}); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _ := index.ApplyEvent(ctx, proposed(medication, "medication", "TREAT")); owner != legacy {
+		t.Errorf("expected the legacy consent to conflict with a new proposal for the same parties, got %s", owner)
+	}
+
+	if err := index.Release(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _ := index.ApplyEvent(ctx, proposed(medication, "medication", "TREAT")); owner != medication {
+		t.Errorf("expected the proposal to claim the key, got %s", owner)
+	}
+	if owner, _ := index.ApplyEvent(ctx, proposed(labResults, "lab-results", "TREAT")); owner != labResults {
+		t.Errorf("expected a proposal for another class not to conflict, got %s", owner)
+	}
+}
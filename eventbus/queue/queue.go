@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	eh "github.com/looplab/eventhorizon"
+	"log"
+	"sync"
+)
+
+// ErrorsSize is the number of handling errors kept until they are read from the Errors channel
+const ErrorsSize = 100
+
+// EventBus delivers the published events to every matching handler, in order of publication.
+// The local event bus of eventhorizon drops the events for a handler when its queue is full. The queue of a handler
+// on this bus has no limit, so publishing never blocks, also not when a handler publishes events itself, and no event
+// is lost while the process runs.
+type EventBus struct {
+	queues     []*queue
+	registered map[eh.EventHandlerType]bool
+	mu         sync.RWMutex
+	errCh      chan eh.EventBusError
+}
+
+var _ = eh.EventBus(&EventBus{})
+
+// NewEventBus creates an EventBus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		registered: map[eh.EventHandlerType]bool{},
+		errCh:      make(chan eh.EventBusError, ErrorsSize),
+	}
+}
+
+type evt struct {
+	ctx   context.Context
+	event eh.Event
+}
+
+// queue holds the events which have not been handled yet by a handler
+type queue struct {
+	matcher eh.EventMatcher
+	handler eh.EventHandler
+	events  []evt
+	mu      sync.Mutex
+	cond    *sync.Cond
+}
+
+// PublishEvent implements the PublishEvent method of the eventhorizon.EventBus interface
+func (b *EventBus) PublishEvent(ctx context.Context, event eh.Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, q := range b.queues {
+		q.mu.Lock()
+		q.events = append(q.events, evt{ctx: ctx, event: event})
+		q.mu.Unlock()
+		q.cond.Signal()
+	}
+	return nil
+}
+
+// AddHandler implements the AddHandler method of the eventhorizon.EventBus interface
+func (b *EventBus) AddHandler(m eh.EventMatcher, h eh.EventHandler) {
+	b.add(m, h, false)
+}
+
+// AddObserver implements the AddObserver method of the eventhorizon.EventBus interface.
+// The bus is local to the process, so an observer only differs from a handler in that its type may be added twice.
+func (b *EventBus) AddObserver(m eh.EventMatcher, h eh.EventHandler) {
+	b.add(m, h, true)
+}
+
+// Errors implements the Errors method of the eventhorizon.EventBus interface
+func (b *EventBus) Errors() <-chan eh.EventBusError {
+	return b.errCh
+}
+
+func (b *EventBus) add(m eh.EventMatcher, h eh.EventHandler, observer bool) {
+	if m == nil {
+		panic("matcher can't be nil")
+	}
+	if h == nil {
+		panic("handler can't be nil")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !observer {
+		if b.registered[h.HandlerType()] {
+			panic(fmt.Sprintf("multiple registrations for %s", h.HandlerType()))
+		}
+		b.registered[h.HandlerType()] = true
+	}
+	q := &queue{matcher: m, handler: h}
+	q.cond = sync.NewCond(&q.mu)
+	b.queues = append(b.queues, q)
+	go b.handle(q)
+}
+
+// handle passes the events in the queue to the handler, one at a time
+func (b *EventBus) handle(q *queue) {
+	for {
+		q.mu.Lock()
+		for len(q.events) == 0 {
+			q.cond.Wait()
+		}
+		e := q.events[0]
+		q.events[0] = evt{}
+		q.events = q.events[1:]
+		q.mu.Unlock()
+
+		if !q.matcher(e.event) {
+			continue
+		}
+		if err := q.handler.HandleEvent(e.ctx, e.event); err != nil {
+			busErr := eh.EventBusError{Err: fmt.Errorf("could not handle event (%s): %s", q.handler.HandlerType(), err.Error()), Ctx: e.ctx, Event: e.event}
+			select {
+			case b.errCh <- busErr:
+			default:
+				log.Printf("[EventBus] error channel full: %v\n", busErr)
+			}
+		}
+	}
+}
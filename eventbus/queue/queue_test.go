@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testEvent = eh.EventType("test:event")
+const followUpEvent = eh.EventType("test:follow-up")
+
+// recorder records the versions of the events it handles, it publishes a follow up for every test event
+type recorder struct {
+	bus      *EventBus
+	versions []int
+	mu       sync.Mutex
+}
+
+func (r *recorder) HandlerType() eh.EventHandlerType {
+	return "recorder"
+}
+
+func (r *recorder) HandleEvent(ctx context.Context, event eh.Event) error {
+	r.mu.Lock()
+	r.versions = append(r.versions, event.Version())
+	r.mu.Unlock()
+	if event.EventType() == testEvent {
+		return r.bus.PublishEvent(ctx, eh.NewEvent(followUpEvent, nil, time.Now()))
+	}
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.versions)
+}
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	handler := &recorder{bus: bus}
+	bus.AddHandler(eh.MatchEvent(testEvent), handler)
+
+	// far more than the local event bus of eventhorizon queues per handler
+	const n = 5000
+	id := uuid.New()
+	for i := 1; i <= n; i++ {
+		if err := bus.PublishEvent(context.Background(), eh.NewEventForAggregate(testEvent, nil, time.Now(), "test", id, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for handler.count() < n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if len(handler.versions) != n {
+		t.Fatalf("expected %d events, got %d", n, len(handler.versions))
+	}
+	for i, version := range handler.versions {
+		if version != i+1 {
+			t.Fatalf("expected the events in order of publication, got version %d at %d", version, i)
+		}
+	}
+}
+
+func TestEventBus_AddHandler(t *testing.T) {
+	bus := NewEventBus()
+	bus.AddHandler(eh.MatchAny(), &recorder{bus: bus})
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a second registration of the handler type")
+		}
+	}()
+	bus.AddHandler(eh.MatchAny(), &recorder{bus: bus})
+}
@@ -0,0 +1,51 @@
+// Package bolttest provides a bolt event store for tests of components which rebuild their state from the stored events.
+package bolttest
+
+import (
+	"context"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/eventstore/bolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Restart saves the events in a new bolt event store, closes and reopens the store as on a restart of the service
+// and returns all events loaded from it. The events of an aggregate must be given in order of their version.
+func Restart(t *testing.T, history []eh.Event) []eh.Event {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "bolttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.db")
+
+	store, err := bolt.NewEventStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range history {
+		if err := store.Save(ctx, []eh.Event{event}, event.Version()-1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = bolt.NewEventStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	loaded, err := store.LoadAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(history) {
+		t.Fatalf("expected %d events after the restart, got %d", len(history), len(loaded))
+	}
+	return loaded
+}
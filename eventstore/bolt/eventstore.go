@@ -0,0 +1,238 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	bbolt "go.etcd.io/bbolt"
+	"reflect"
+	"time"
+)
+
+// ErrCouldNotSaveAggregate is returned when the events could not be stored, the cause is in the BaseErr of the error.
+var ErrCouldNotSaveAggregate = errors.New("could not save aggregate")
+
+// ErrVersionConflict is returned when the aggregate has been changed since it was loaded.
+var ErrVersionConflict = errors.New("aggregate changed since it was loaded")
+
+// Within a namespace bucket all events are kept in a single log, ordered by a global sequence number.
+// Per aggregate an index bucket maps the aggregate version to the position in the log.
+var logBucket = []byte("log")
+var aggregatesBucket = []byte("aggregates")
+
+// EventStore implements eh.EventStore on top of a BoltDB file.
+type EventStore struct {
+	db *bbolt.DB
+}
+
+// NewEventStore opens or creates the BoltDB file at path.
+func NewEventStore(path string) (*EventStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &EventStore{db: db}, nil
+}
+
+// Close closes the underlying BoltDB file.
+func (s *EventStore) Close() error {
+	return s.db.Close()
+}
+
+// Save implements the Save method of the eventhorizon.EventStore interface.
+func (s *EventStore) Save(ctx context.Context, events []eh.Event, originalVersion int) error {
+	ns := eh.NamespaceFromContext(ctx)
+	if len(events) == 0 {
+		return eh.EventStoreError{Err: eh.ErrNoEventsToAppend, Namespace: ns}
+	}
+
+	aggregateID := events[0].AggregateID()
+	records := make([][]byte, len(events))
+	version := originalVersion
+	for i, event := range events {
+		// Only accept events belonging to the same aggregate.
+		if event.AggregateID() != aggregateID {
+			return eh.EventStoreError{Err: eh.ErrInvalidEvent, Namespace: ns}
+		}
+		// Only accept events that apply to the correct aggregate version.
+		if event.Version() != version+1 {
+			return eh.EventStoreError{Err: eh.ErrIncorrectEventVersion, Namespace: ns}
+		}
+		record, err := newDBEvent(event)
+		if err != nil {
+			return eh.EventStoreError{Err: eh.ErrInvalidEvent, BaseErr: err, Namespace: ns}
+		}
+		records[i] = record
+		version++
+	}
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		nsBucket, err := tx.CreateBucketIfNotExists([]byte(ns))
+		if err != nil {
+			return err
+		}
+		log, err := nsBucket.CreateBucketIfNotExists(logBucket)
+		if err != nil {
+			return err
+		}
+		aggregates, err := nsBucket.CreateBucketIfNotExists(aggregatesBucket)
+		if err != nil {
+			return err
+		}
+		index, err := aggregates.CreateBucketIfNotExists(aggregateID[:])
+		if err != nil {
+			return err
+		}
+
+		// Optimistic concurrency: only append when nobody else did since the aggregate was loaded.
+		// Bolt allows a single writer, so the check and the append are atomic.
+		if currentVersion(index) != originalVersion {
+			return ErrVersionConflict
+		}
+
+		for i, record := range records {
+			seq, err := log.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := log.Put(itob(seq), record); err != nil {
+				return err
+			}
+			if err := index.Put(itob(uint64(originalVersion+i+1)), itob(seq)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == ErrVersionConflict {
+		return eh.EventStoreError{Err: ErrVersionConflict, Namespace: ns}
+	}
+	if err != nil {
+		return eh.EventStoreError{Err: ErrCouldNotSaveAggregate, BaseErr: err, Namespace: ns}
+	}
+	return nil
+}
+
+// Load implements the Load method of the eventhorizon.EventStore interface.
+func (s *EventStore) Load(ctx context.Context, id uuid.UUID) ([]eh.Event, error) {
+	ns := eh.NamespaceFromContext(ctx)
+	events := []eh.Event{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		nsBucket := tx.Bucket([]byte(ns))
+		if nsBucket == nil {
+			return nil
+		}
+		index := nsBucket.Bucket(aggregatesBucket).Bucket(id[:])
+		if index == nil {
+			return nil
+		}
+		log := nsBucket.Bucket(logBucket)
+		return index.ForEach(func(_, seq []byte) error {
+			event, err := decodeEvent(log.Get(seq))
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, eh.EventStoreError{Err: err, Namespace: ns}
+	}
+	return events, nil
+}
+
+// LoadAll returns all events in the namespace in the order they were saved. Useful to replay events into read models.
+func (s *EventStore) LoadAll(ctx context.Context) ([]eh.Event, error) {
+	ns := eh.NamespaceFromContext(ctx)
+	events := []eh.Event{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		nsBucket := tx.Bucket([]byte(ns))
+		if nsBucket == nil {
+			return nil
+		}
+		return nsBucket.Bucket(logBucket).ForEach(func(_, record []byte) error {
+			event, err := decodeEvent(record)
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, eh.EventStoreError{Err: err, Namespace: ns}
+	}
+	return events, nil
+}
+
+// currentVersion returns the version of the last event in the aggregate index
+func currentVersion(index *bbolt.Bucket) int {
+	k, _ := index.Cursor().Last()
+	if k == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(k))
+}
+
+// itob returns an 8-byte big endian representation of v, which keeps bolt keys sorted numerically
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// dbEvent is the serialized form of an event
+type dbEvent struct {
+	EventType     eh.EventType
+	RawData       json.RawMessage `json:",omitempty"`
+	Timestamp     time.Time
+	AggregateType eh.AggregateType
+	AggregateID   uuid.UUID
+	Version       int
+}
+
+func newDBEvent(event eh.Event) ([]byte, error) {
+	e := dbEvent{
+		EventType:     event.EventType(),
+		Timestamp:     event.Timestamp(),
+		AggregateType: event.AggregateType(),
+		AggregateID:   event.AggregateID(),
+		Version:       event.Version(),
+	}
+	if event.Data() != nil {
+		raw, err := json.Marshal(event.Data())
+		if err != nil {
+			return nil, err
+		}
+		e.RawData = raw
+	}
+	return json.Marshal(e)
+}
+
+func decodeEvent(record []byte) (eh.Event, error) {
+	e := dbEvent{}
+	if err := json.Unmarshal(record, &e); err != nil {
+		return nil, err
+	}
+
+	var data eh.EventData
+	if len(e.RawData) > 0 {
+		ptr, err := eh.CreateEventData(e.EventType)
+		if err != nil {
+			return nil, fmt.Errorf("could not create event data for %s: %w", e.EventType, err)
+		}
+		if err := json.Unmarshal(e.RawData, ptr); err != nil {
+			return nil, fmt.Errorf("could not decode event data for %s: %w", e.EventType, err)
+		}
+		// Event data is registered as a pointer, but stored by the aggregates as a value.
+		// Dereference so handlers see the same types as for freshly stored events.
+		data = reflect.Indirect(reflect.ValueOf(ptr)).Interface()
+	}
+
+	return eh.NewEventForAggregate(e.EventType, data, e.Timestamp, e.AggregateType, e.AggregateID, e.Version), nil
+}
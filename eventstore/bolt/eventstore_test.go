@@ -0,0 +1,158 @@
+package bolt
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) (*EventStore, string, func()) {
+	dir, err := ioutil.TempDir("", "eventstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "events.db")
+	store, err := NewEventStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, path, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEventStore_SaveAndLoad(t *testing.T) {
+	store, path, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	id := uuid.New()
+	timestamp := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	proposed := eh.NewEventForAggregate(events.Proposed, events.ProposedData{
+		ID:          id,
+		CustodianID: "agb:123",
+		SubjectID:   "bsn:999",
+		ActorID:     "agb:456",
+		Start:       timestamp,
+	}, timestamp, consent.ConsentAggregateType, id, 1)
+	unique := eh.NewEventForAggregate(events.Unique, nil, timestamp, consent.ConsentAggregateType, id, 2)
+
+	if err := store.Save(ctx, []eh.Event{proposed}, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Save(ctx, []eh.Event{unique}, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("load", func(t *testing.T) {
+		loaded, err := store.Load(ctx, id)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(loaded, []eh.Event{proposed, unique}) {
+			t.Errorf("incorrect events")
+			t.Logf("got: %#v", loaded)
+		}
+	})
+
+	t.Run("load unknown aggregate", func(t *testing.T) {
+		loaded, err := store.Load(ctx, uuid.New())
+		if err != nil || len(loaded) != 0 {
+			t.Errorf("expected no events and no error, got %v, %v", loaded, err)
+		}
+	})
+
+	t.Run("events survive a reopen", func(t *testing.T) {
+		store.Close()
+		reopened, err := NewEventStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store = reopened
+		loaded, err := store.LoadAll(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(loaded, []eh.Event{proposed, unique}) {
+			t.Errorf("incorrect events")
+			t.Logf("got: %#v", loaded)
+		}
+	})
+}
+
+func TestEventStore_Save_Errors(t *testing.T) {
+	store, _, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	id := uuid.New()
+	timestamp := time.Now()
+	first := eh.NewEventForAggregate(events.Unique, nil, timestamp, consent.ConsentAggregateType, id, 1)
+	if err := store.Save(ctx, []eh.Event{first}, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := map[string]struct {
+		events          []eh.Event
+		originalVersion int
+		expectedErr     error
+	}{
+		"no events": {
+			[]eh.Event{},
+			0,
+			eh.ErrNoEventsToAppend,
+		},
+		"incorrect version": {
+			[]eh.Event{eh.NewEventForAggregate(events.Unique, nil, timestamp, consent.ConsentAggregateType, id, 3)},
+			1,
+			eh.ErrIncorrectEventVersion,
+		},
+		"stale aggregate version": {
+			[]eh.Event{eh.NewEventForAggregate(events.Unique, nil, timestamp, consent.ConsentAggregateType, id, 1)},
+			0,
+			ErrVersionConflict,
+		},
+		"mixed aggregates": {
+			[]eh.Event{
+				eh.NewEventForAggregate(events.Unique, nil, timestamp, consent.ConsentAggregateType, id, 2),
+				eh.NewEventForAggregate(events.Unique, nil, timestamp, consent.ConsentAggregateType, uuid.New(), 3),
+			},
+			1,
+			eh.ErrInvalidEvent,
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := store.Save(ctx, testcase.events, testcase.originalVersion)
+			esErr, ok := err.(eh.EventStoreError)
+			if !ok || esErr.Err != testcase.expectedErr {
+				t.Errorf("expected %v, got %v", testcase.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestEventStore_Save_StorageFailure(t *testing.T) {
+	store, _, cleanup := newTestStore(t)
+	defer cleanup()
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	id := uuid.New()
+	err := store.Save(context.Background(), []eh.Event{eh.NewEventForAggregate(events.Unique, nil, time.Now(), consent.ConsentAggregateType, id, 1)}, 0)
+	esErr, ok := err.(eh.EventStoreError)
+	if !ok || esErr.Err != ErrCouldNotSaveAggregate || esErr.BaseErr == nil {
+		t.Errorf("expected a storage failure with its cause, got %v", err)
+	}
+}
@@ -5,6 +5,6 @@ go 1.13
 require (
 	github.com/google/uuid v1.1.1
 	github.com/looplab/eventhorizon v0.6.0
-	github.com/nuts-foundation/nuts-consent-logic v0.13.1 // indirect
 	github.com/nuts-foundation/nuts-crypto v0.13.2
+	go.etcd.io/bbolt v1.3.5
 )
@@ -2,29 +2,58 @@ package main
 
 import (
 	"context"
-	"github.com/google/uuid"
+	"flag"
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/aggregatestore/events"
 	"github.com/looplab/eventhorizon/commandhandler/aggregate"
 	"github.com/looplab/eventhorizon/commandhandler/bus"
-	"github.com/looplab/eventhorizon/eventbus/local"
-	projector2 "github.com/looplab/eventhorizon/eventhandler/projector"
 	"github.com/looplab/eventhorizon/eventhandler/saga"
-	"github.com/looplab/eventhorizon/eventstore/memory"
 	memory2 "github.com/looplab/eventhorizon/repo/memory"
 	"github.com/looplab/eventhorizon/repo/version"
+	"github.com/nuts-foundation/nuts-consent-service/api"
+	"github.com/nuts-foundation/nuts-consent-service/codelist"
 	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
 	events2 "github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain/negotiation"
 	"github.com/nuts-foundation/nuts-consent-service/domain/sagas"
+	"github.com/nuts-foundation/nuts-consent-service/domain/uniqueness"
+	"github.com/nuts-foundation/nuts-consent-service/eventbus/queue"
+	"github.com/nuts-foundation/nuts-consent-service/eventstore/bolt"
+	"github.com/nuts-foundation/nuts-consent-service/negotiator"
+	"github.com/nuts-foundation/nuts-consent-service/negotiator/local"
+	"github.com/nuts-foundation/nuts-consent-service/negotiator/remote"
+	"github.com/nuts-foundation/nuts-consent-service/projection"
+	"github.com/nuts-foundation/nuts-consent-service/query"
+	"github.com/nuts-foundation/nuts-consent-service/registry"
+	"github.com/nuts-foundation/nuts-consent-service/registry/crypto"
+	memory3 "github.com/nuts-foundation/nuts-consent-service/registry/memory"
+	"github.com/nuts-foundation/nuts-crypto/pkg"
 	"log"
+	"net/http"
 	"time"
 )
 
 func main() {
 	println("nuts consent service")
 
-	eventstore := memory.NewEventStore()
-	eventbus := local.NewEventBus(local.NewGroup())
+	eventStorePath := flag.String("eventstore", "consent-events.db", "path of the event store database file")
+	registryPath := flag.String("registry", "registry.json", "path of a JSON file with the known parties and their consent endpoints")
+	skipCustodianKeyCheck := flag.Bool("skip-custodian-key-check", false, "accept every party in the registry as custodian without checking nuts-crypto for its key, for local development only")
+	codeListPath := flag.String("codelist", "", "path of a JSON file with the allowed consent classes and purposes of use, a default list is used when not set")
+	checkTimeout := flag.Duration("check-timeout", time.Minute, "time in which all checks on a proposed consent must pass")
+	negotiationTimeout := flag.Duration("negotiation-timeout", time.Hour, "time in which the negotiation of a consent must complete")
+	negotiatorType := flag.String("negotiator", "remote", "negotiator of the contracts: remote sends them to the consent endpoints of the parties, local signs them on their behalf")
+	address := flag.String("address", ":1323", "address of the HTTP API")
+	adminToken := flag.String("admin-token", "", "bearer token required to rebuild projections over HTTP, rebuilding over HTTP is disabled when not set")
+	flag.Parse()
+
+	eventstore, err := bolt.NewEventStore(*eventStorePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer eventstore.Close()
+	eventbus := queue.NewEventBus()
 	commandBus := bus.NewCommandHandler()
 
 	eventLogger := &EventLogger{}
@@ -35,15 +64,23 @@ func main() {
 		log.Fatal(err)
 	}
 
-	consentCommandHandler, err := aggregate.NewCommandHandler(consent.ConsentAggregateType, aggregateStore)
+	aggregateCommandHandler, err := aggregate.NewCommandHandler(consent.ConsentAggregateType, aggregateStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	consentCommandHandler := eh.UseCommandHandlerMiddleware(aggregateCommandHandler, RetryOnConflict(3))
+
+	negotiationCommandHandler, err := aggregate.NewCommandHandler(negotiation.ConsentNegotiationAggregateType, aggregateStore)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	//negotiationCommandHandler, err := aggregate.NewCommandHandler(negotiation.ConsentNegotiationAggregateType, aggregateStore)
-	//if err != nil {
-	//	log.Fatal(err)
-	//}
+	deadlineAggregateHandler, err := aggregate.NewCommandHandler(deadline.DeadlineAggregateType, aggregateStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	deadlineCommandHandler := eh.UseCommandHandlerMiddleware(deadlineAggregateHandler, RetryOnConflict(3))
 
 	//consentCommandHandler = eh.UseCommandHandlerMiddleware(consentCommandHandler, eventLogger.CommandLogger)
 	//negotiationCommandHandler = eh.UseCommandHandlerMiddleware(negotiationCommandHandler, eventLogger.CommandLogger)
@@ -57,36 +94,142 @@ func main() {
 	if err := commandBus.SetHandler(consentCommandHandler, consent.MarkAsUniqueCmdType); err != nil {
 		panic(err)
 	}
+	if err := commandBus.SetHandler(consentCommandHandler, consent.MarkCustodianCheckedCmdType); err != nil {
+		panic(err)
+	}
 	commandBus.SetHandler(consentCommandHandler, consent.StartSyncCmdType)
+	if err := commandBus.SetHandler(consentCommandHandler, consent.MarkAsCompletedCmdType); err != nil {
+		panic(err)
+	}
+	if err := commandBus.SetHandler(consentCommandHandler, consent.ExpireCmdType); err != nil {
+		panic(err)
+	}
+	if err := commandBus.SetHandler(consentCommandHandler, consent.WithdrawCmdType); err != nil {
+		panic(err)
+	}
+	if err := commandBus.SetHandler(consentCommandHandler, consent.AmendCmdType); err != nil {
+		panic(err)
+	}
+	for _, cmdType := range []eh.CommandType{negotiation.StartNegotiationCmdType, negotiation.RecordVendorResponseCmdType, negotiation.CompleteNegotiationCmdType} {
+		if err := commandBus.SetHandler(negotiationCommandHandler, cmdType); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, cmdType := range []eh.CommandType{deadline.ScheduleDeadlineCmdType, deadline.CancelDeadlineCmdType, deadline.FireDeadlineCmdType} {
+		if err := commandBus.SetHandler(deadlineCommandHandler, cmdType); err != nil {
+			panic(err)
+		}
+	}
+
+	uniquenessIndex := uniqueness.NewIndex(memory2.NewRepo())
+	uniquenessSaga := sagas.NewUniquenessSaga(uniquenessIndex)
+	eventbus.AddHandler(uniquenessSaga.MatchEvents(), saga.NewEventHandler(uniquenessSaga, commandBus))
+
+	consentMatcher := eh.MatchAggregate(consent.ConsentAggregateType)
+	syncProjection := projection.New(&consent.SyncProjector{}, consentMatcher,
+		func() eh.Entity { return &consent.ConsentNegotiation{} },
+		func() eh.ReadWriteRepo { return version.NewRepo(memory2.NewRepo()) })
+	eventbus.AddHandler(consentMatcher, syncProjection)
+	negotiationRepo := syncProjection.Repo()
 
-	uniquenessSaga := saga.NewEventHandler(sagas.NewUniquenessSaga(), commandBus)
-	eventbus.AddHandler(eh.MatchEvent(events2.Proposed), uniquenessSaga)
+	consentProjection := projection.New(&consent.ConsentProjector{}, consentMatcher,
+		func() eh.Entity { return &consent.Consent{} },
+		func() eh.ReadWriteRepo { return version.NewRepo(query.NewRepo(memory2.NewRepo())) })
+	eventbus.AddHandler(consentMatcher, consentProjection)
 
-	negotiationRepo := version.NewRepo(memory2.NewRepo())
-	projector := projector2.NewEventHandler(&consent.SyncProjector{}, negotiationRepo)
-	projector.SetEntityFactory(func() eh.Entity { return &consent.ConsentNegotiation{} })
-	eventbus.AddHandler(eh.MatchAny(), projector)
+	// The read models and uniqueness index are kept in memory, rebuild them from the persisted events
+	history, err := eventstore.LoadAll(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := uniquenessIndex.Rebuild(context.Background(), history); err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range []*projection.Projection{syncProjection, consentProjection} {
+		if err := p.Rebuild(context.Background(), eventstore.LoadAll, projection.LogProgress(p.Type())); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	partyRegistry, err := memory3.LoadRegistry(*registryPath)
+	if err != nil {
+		log.Fatalf("could not load the party registry: %v", err)
+	}
+	// Only parties for which nuts-crypto holds a key can be custodian, unless the check is skipped
+	var custodians registry.PartyRegistry = crypto.NewRegistry(pkg.NewCryptoClient(), partyRegistry)
+	if *skipCustodianKeyCheck {
+		log.Println("custodian key check skipped, every party in the registry is accepted as custodian")
+		custodians = partyRegistry
+	}
+
+	codes := codelist.Default()
+	if *codeListPath != "" {
+		if codes, err = codelist.Load(*codeListPath); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	syncSaga := saga.NewEventHandler(sagas.SyncSaga{NegotiationRepo: negotiationRepo}, commandBus)
-	eventbus.AddHandler(eh.MatchAnyEventOf(events2.Unique), syncSaga)
+	// The negotiator distributes the contract when the consent has started syncing
+	var contractNegotiator interface {
+		negotiator.Negotiator
+		eh.EventHandler
+	}
+	switch *negotiatorType {
+	case "remote":
+		remoteNegotiator := remote.NewNegotiator(partyRegistry, commandBus, aggregateStore)
+		if err := commandBus.SetHandler(remoteNegotiator, remote.DistributeContractCmdType); err != nil {
+			log.Fatal(err)
+		}
+		contractNegotiator = remoteNegotiator
+	case "local":
+		contractNegotiator = &local.LocalNegotiator{CommandHandler: commandBus, Negotiations: aggregateStore}
+	default:
+		log.Fatalf("unknown negotiator: %s", *negotiatorType)
+	}
+	eventbus.AddHandler(eh.MatchEvent(events2.SyncStarted), contractNegotiator)
+
+	// The SyncSaga schedules the start of the sync, the SyncStarter starts the negotiation when the deadline passes
+	syncStarter := &sagas.SyncStarter{NegotiationRepo: negotiationRepo, Negotiator: contractNegotiator, CommandHandler: commandBus}
+	if err := commandBus.SetHandler(syncStarter, sagas.SyncConsentCmdType); err != nil {
+		log.Fatal(err)
+	}
+	syncSaga := sagas.SyncSaga{}
+	checksProcessManager := sagas.NewChecksProcessManager(memory2.NewRepo(), syncSaga, *checkTimeout, events2.Unique, events2.CustodianChecked)
+	if err := checksProcessManager.Rebuild(context.Background(), history); err != nil {
+		log.Fatal(err)
+	}
+	eventbus.AddHandler(checksProcessManager.MatchEvents(), saga.NewEventHandler(checksProcessManager, commandBus))
 
-	checkPartiesSaga := saga.NewEventHandler(sagas.CheckPartiesSaga{},commandBus)
-	eventbus.AddHandler(eh.MatchAnyEventOf(events2.Proposed), checkPartiesSaga)
+	expiryScheduler := sagas.NewExpiryScheduler(memory2.NewRepo())
+	if err := expiryScheduler.Rebuild(context.Background(), history); err != nil {
+		log.Fatal(err)
+	}
+	eventbus.AddHandler(expiryScheduler.MatchEvents(), saga.NewEventHandler(expiryScheduler, commandBus))
 
-	id := uuid.New()
+	// Sagas schedule deadlines by dispatching ScheduleDeadline, the scheduler dispatches their commands when they pass
+	deadlineScheduler := deadline.NewScheduler(memory2.NewRepo())
+	if err := deadlineScheduler.Rebuild(context.Background(), history); err != nil {
+		log.Fatal(err)
+	}
+	eventbus.AddHandler(deadlineScheduler.MatchEvents(), deadlineScheduler)
+	go deadlineScheduler.Watch(context.Background(), commandBus, time.Second)
+
+	negotiationDeadlineSaga := sagas.NegotiationDeadlineSaga{Timeout: *negotiationTimeout}
+	eventbus.AddHandler(negotiationDeadlineSaga.MatchEvents(), saga.NewEventHandler(negotiationDeadlineSaga, commandBus))
 
-	proposeConsentCmd := &consent.Propose{
-		ID:          id,
-		CustodianID: "agb:123",
-		SubjectID:   "bsn:999",
-		ActorID:     "agb:456",
-		Start:       time.Now(),
+	withdrawalPropagator := &sagas.WithdrawalPropagator{NegotiationRepo: negotiationRepo, Negotiator: contractNegotiator, CommandHandler: commandBus}
+	if err := commandBus.SetHandler(withdrawalPropagator, sagas.PropagateWithdrawalCmdType); err != nil {
+		log.Fatal(err)
 	}
+	withdrawalSaga := sagas.WithdrawalSaga{}
+	eventbus.AddHandler(withdrawalSaga.MatchEvents(), saga.NewEventHandler(withdrawalSaga, commandBus))
 
-	err = commandBus.HandleCommand(context.Background(), proposeConsentCmd)
+	negotiationSaga := sagas.NegotiationSaga{}
+	eventbus.AddHandler(negotiationSaga.MatchEvents(), saga.NewEventHandler(negotiationSaga, commandBus))
 
-	//proposeConsentCmd.ID = uuid.New()
-	//err = commandBus.HandleCommand(context.Background(), proposeConsentCmd)
+	checkPartiesSaga := saga.NewEventHandler(sagas.CheckPartiesSaga{Registry: partyRegistry, Custodians: custodians, Codes: codes}, commandBus)
+	eventbus.AddHandler(eh.MatchAnyEventOf(events2.Proposed, events2.Amended), checkPartiesSaga)
 
 	go func() {
 		for e := range eventbus.Errors() {
@@ -94,7 +237,12 @@ func main() {
 		}
 	}()
 
-	time.Sleep(5*time.Second)
+	mux := http.NewServeMux()
+	consentAPI := api.API{CommandHandler: commandBus, Consents: query.NewService(consentProjection.Repo()), Codes: codes}
+	mux.Handle("/consent", consentAPI)
+	mux.Handle("/consent/", consentAPI)
+	mux.Handle("/projections/", api.Projections{Load: eventstore.LoadAll, Projections: []*projection.Projection{syncProjection, consentProjection}, Token: *adminToken})
 
-	println("end")
+	log.Printf("listening on %s\n", *address)
+	log.Fatal(http.ListenAndServe(*address, mux))
 }
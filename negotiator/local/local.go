@@ -1,15 +1,95 @@
 package local
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain/negotiation"
+	"github.com/nuts-foundation/nuts-consent-service/negotiator"
 	"log"
 )
 
+// VendorID is the vendor which signs the contract on behalf of every party
+const VendorID = "local"
+
+const HandlerType = eh.EventHandlerType("local-negotiator")
+
+// LocalNegotiator signs the contract on behalf of every party, without contacting them.
+// It runs the same NegotiationAggregate as the remote negotiator, which makes it useful for development and testing.
+// The contract is signed when the consent has started syncing, so the negotiator must receive the SyncStarted events.
 type LocalNegotiator struct {
+	CommandHandler eh.CommandHandler
+	Negotiations   eh.AggregateStore
+}
+
+var _ = negotiator.Negotiator(&LocalNegotiator{})
+var _ = eh.EventHandler(&LocalNegotiator{})
+
+// Start implements the Start method of the negotiator.Negotiator interface.
+// Every party but the subject is represented by the local vendor.
+func (l *LocalNegotiator) Start(syncID uuid.UUID, consentID uuid.UUID, parties []negotiation.Party, contents string) error {
+	cmd := &negotiation.StartNegotiation{ID: syncID, ConsentID: consentID, Contents: contents}
+	for _, party := range parties {
+		if party.Role == negotiation.SubjectRole {
+			continue
+		}
+		party.Vendor = []string{VendorID}
+		cmd.Parties = append(cmd.Parties, party)
+	}
+
+	err := l.CommandHandler.HandleCommand(context.Background(), cmd)
+	if errors.Is(err, domain.ErrInvalidTransition) {
+		// started by a previous attempt
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not start the negotiation: %w", err)
+	}
+	log.Printf("sync started with id: %s\n", syncID)
+	return nil
 }
 
-func (l LocalNegotiator) Start(parties []string, contents string) (uuid.UUID, error) {
-	id := uuid.New()
-	log.Printf("sync started with id: %s\n", id)
-	return id, nil
+// Withdraw implements the Withdraw method of the negotiator.Negotiator interface, there is nobody to inform
+func (l *LocalNegotiator) Withdraw(consentID uuid.UUID, syncID uuid.UUID, parties []negotiation.Party, withdrawnBy string, reason string) error {
+	log.Printf("sync %s withdrawn by %s\n", syncID, withdrawnBy)
+	return nil
+}
+
+func (l *LocalNegotiator) HandlerType() eh.EventHandlerType {
+	return HandlerType
+}
+
+// HandleEvent signs the contract for every party and completes the negotiation when the consent has started syncing
+func (l *LocalNegotiator) HandleEvent(ctx context.Context, event eh.Event) error {
+	if event.EventType() != events.SyncStarted {
+		return nil
+	}
+	data, ok := event.Data().(events.SyncStartedData)
+	if !ok {
+		return errors.New("event data of wrong type")
+	}
+	agg, err := l.Negotiations.Load(ctx, negotiation.ConsentNegotiationAggregateType, data.SyncID)
+	if err != nil {
+		return fmt.Errorf("could not load negotiation %s: %w", data.SyncID, err)
+	}
+	started, ok := agg.(*negotiation.NegotiationAggregate)
+	if !ok || started.State != negotiation.NegotiationPending {
+		log.Printf("no pending negotiation %s\n", data.SyncID)
+		return nil
+	}
+
+	for _, party := range started.Parties {
+		if party.Signed() {
+			continue
+		}
+		cmd := &negotiation.RecordVendorResponse{ID: data.SyncID, PartyID: party.ID, VendorID: VendorID, Signed: true}
+		if err := l.CommandHandler.HandleCommand(ctx, cmd); err != nil {
+			return fmt.Errorf("could not sign for party %s: %w", party.ID, err)
+		}
+	}
+	return l.CommandHandler.HandleCommand(ctx, &negotiation.CompleteNegotiation{ID: data.SyncID})
 }
@@ -0,0 +1,52 @@
+package local
+
+import (
+	"context"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/looplab/eventhorizon/commandhandler/aggregate"
+	"github.com/looplab/eventhorizon/eventbus/local"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	events2 "github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain/negotiation"
+	"testing"
+)
+
+func TestLocalNegotiator(t *testing.T) {
+	store, err := events.NewAggregateStore(memory.NewEventStore(), local.NewEventBus(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commandHandler, err := aggregate.NewCommandHandler(negotiation.ConsentNegotiationAggregateType, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	negotiator := &LocalNegotiator{CommandHandler: commandHandler, Negotiations: store}
+	parties := []negotiation.Party{
+		{ID: "bsn:999999990", Role: negotiation.SubjectRole},
+		{ID: "agb:123", Role: negotiation.CustodianRole},
+		{ID: "agb:456", Role: negotiation.ActorRole},
+	}
+	consentID, syncID := uuid.New(), uuid.New()
+
+	if err := negotiator.Start(syncID, consentID, parties, "contract"); err != nil {
+		t.Fatal(err)
+	}
+	if err := negotiator.Start(syncID, consentID, parties, "contract"); err != nil {
+		t.Errorf("expected starting the same negotiation again to succeed, got: %v", err)
+	}
+	event := eh.NewEventForAggregate(events2.SyncStarted, events2.SyncStartedData{SyncID: syncID}, consent.TimeNow(), consent.ConsentAggregateType, consentID, 4)
+	if err := negotiator.HandleEvent(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	agg, err := store.Load(context.Background(), negotiation.ConsentNegotiationAggregateType, syncID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := agg.(*negotiation.NegotiationAggregate).State; state != negotiation.NegotiationCompleted {
+		t.Errorf("expected the negotiation to be completed, got: %s", state)
+	}
+}
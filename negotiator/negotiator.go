@@ -1,7 +1,15 @@
 package negotiator
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"github.com/nuts-foundation/nuts-consent-service/domain/negotiation"
+)
 
+// Negotiator agrees the contract of a consent with all parties involved
 type Negotiator interface {
-	Start(parties []string, contents string) (uuid.UUID, error)
+	// Start starts the negotiation of the contract for the consent under the id of the sync.
+	// Starting a negotiation which has already been started with the same id succeeds, so Start can be retried.
+	Start(syncID uuid.UUID, consentID uuid.UUID, parties []negotiation.Party, contents string) error
+	// Withdraw informs the parties of the sync that the consent has been withdrawn
+	Withdraw(consentID uuid.UUID, syncID uuid.UUID, parties []negotiation.Party, withdrawnBy string, reason string) error
 }
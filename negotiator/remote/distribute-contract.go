@@ -0,0 +1,35 @@
+package remote
+
+import (
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain/negotiation"
+)
+
+const DistributeContractCmdType = eh.CommandType("consent-negotiation:distribute")
+
+func init() {
+	eh.RegisterCommand(func() eh.Command {
+		return &DistributeContract{}
+	})
+}
+
+// DistributeContract sends the contract of the negotiation with the sync id ID to the vendors which have not
+// responded yet. It is handled by the Negotiator and scheduled as deadline, so a distribution which is waiting for
+// an unavailable endpoint survives a restart. Attempt is the number of the try, starting at 1.
+type DistributeContract struct {
+	ID      uuid.UUID
+	Attempt int
+}
+
+func (cmd DistributeContract) AggregateID() uuid.UUID {
+	return cmd.ID
+}
+
+func (cmd DistributeContract) AggregateType() eh.AggregateType {
+	return negotiation.ConsentNegotiationAggregateType
+}
+
+func (cmd DistributeContract) CommandType() eh.CommandType {
+	return DistributeContractCmdType
+}
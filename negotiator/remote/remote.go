@@ -0,0 +1,381 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain/negotiation"
+	"github.com/nuts-foundation/nuts-consent-service/negotiator"
+	"github.com/nuts-foundation/nuts-consent-service/registry"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EndpointType is the type of the registry endpoint at which a party accepts consent contracts
+const EndpointType = "consent"
+
+const HandlerType = eh.EventHandlerType("remote-negotiator")
+
+// DefaultRetry is the schedule of the attempts to send the contract to an unavailable endpoint, before it counts as
+// rejected
+var DefaultRetry = deadline.Retry{Attempts: 3, Delay: time.Second}
+
+// ContractMessage and WithdrawalMessage are the types of the messages posted to the consent endpoints
+const ContractMessage = "contract"
+const WithdrawalMessage = "withdrawal"
+
+// ContractRequest is posted to the consent endpoints of every party
+type ContractRequest struct {
+	Type      string    `json:"type"`
+	SyncID    uuid.UUID `json:"syncID"`
+	ConsentID uuid.UUID `json:"consentID"`
+	Parties   []string  `json:"parties"`
+	Contract  string    `json:"contract"`
+}
+
+// WithdrawalRequest is posted to the consent endpoints of every party when a completed consent is withdrawn
+type WithdrawalRequest struct {
+	Type        string    `json:"type"`
+	SyncID      uuid.UUID `json:"syncID"`
+	ConsentID   uuid.UUID `json:"consentID"`
+	WithdrawnBy string    `json:"withdrawnBy"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// vendorEndpoint is the endpoint at which a vendor signs the contract on behalf of a party
+type vendorEndpoint struct {
+	PartyID  string
+	VendorID string
+	URL      string
+}
+
+// Negotiator distributes the contract of a consent to the consent endpoints of the parties.
+// Every endpoint is operated by a vendor of the party, an endpoint accepting the contract signs it on behalf
+// of the party. The responses are recorded on the NegotiationAggregate, which publishes the progress as events.
+//
+// The endpoints are resolved when the negotiation starts and stored in its events together with the contract.
+// The contract is distributed when the consent has started syncing, so the negotiator must receive the
+// SyncStarted events and handle the DistributeContract commands. It loads the negotiation from the aggregate store, which makes the distribution independent
+// of the instance which started it. The subject is represented by the custodian and does not sign the contract.
+type Negotiator struct {
+	Registry       registry.PartyRegistry
+	CommandHandler eh.CommandHandler
+	Negotiations   eh.AggregateStore
+	Client         *http.Client
+	// Retry is the schedule of the attempts to send the contract to an endpoint which is unavailable
+	Retry deadline.Retry
+}
+
+var _ = negotiator.Negotiator(&Negotiator{})
+var _ = eh.EventHandler(&Negotiator{})
+
+// NewNegotiator creates a Negotiator which resolves the endpoints in the registry, records the responses
+// through the command handler and loads started negotiations from the aggregate store
+func NewNegotiator(partyRegistry registry.PartyRegistry, commandHandler eh.CommandHandler, negotiations eh.AggregateStore) *Negotiator {
+	return &Negotiator{
+		Registry:       partyRegistry,
+		CommandHandler: commandHandler,
+		Negotiations:   negotiations,
+		Client:         &http.Client{Timeout: 10 * time.Second},
+		Retry:          DefaultRetry,
+	}
+}
+
+// Start implements the Start method of the negotiator.Negotiator interface.
+// It starts the NegotiationAggregate for the involved parties, the contract is distributed when the sync has started.
+// Every party other than the subject must be in the registry, the NegotiationAggregate rejects a negotiation
+// without a custodian and an actor with a consent endpoint.
+func (n *Negotiator) Start(syncID uuid.UUID, consentID uuid.UUID, parties []negotiation.Party, contents string) error {
+	cmd := &negotiation.StartNegotiation{ID: syncID, ConsentID: consentID, Contents: contents}
+
+	for _, party := range parties {
+		if party.Role == negotiation.SubjectRole {
+			continue
+		}
+		endpoints, err := n.resolve(party.ID)
+		if err != nil {
+			return err
+		}
+		party.Vendor = nil
+		party.Endpoints = nil
+		for _, endpoint := range endpoints {
+			if party.Endpoints == nil {
+				party.Endpoints = map[string]string{}
+			}
+			party.Vendor = append(party.Vendor, endpoint.VendorID)
+			party.Endpoints[endpoint.VendorID] = endpoint.URL
+		}
+		cmd.Parties = append(cmd.Parties, party)
+	}
+
+	err := n.CommandHandler.HandleCommand(context.Background(), cmd)
+	if errors.Is(err, domain.ErrInvalidTransition) {
+		// started by a previous attempt
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not start the negotiation: %w", err)
+	}
+
+	log.Printf("[RemoteNegotiator] negotiation %s started for consent %s with %d parties\n", syncID, consentID, len(cmd.Parties))
+	return nil
+}
+
+// Withdraw implements the Withdraw method of the negotiator.Negotiator interface.
+// It posts the withdrawal to the consent endpoints of all parties but the subject in parallel and fails when any
+// party cannot be resolved or any endpoint fails.
+func (n *Negotiator) Withdraw(consentID uuid.UUID, syncID uuid.UUID, parties []negotiation.Party, withdrawnBy string, reason string) error {
+	request := WithdrawalRequest{Type: WithdrawalMessage, SyncID: syncID, ConsentID: consentID, WithdrawnBy: withdrawnBy, Reason: reason}
+	var endpoints []vendorEndpoint
+	for _, party := range parties {
+		if party.Role == negotiation.SubjectRole {
+			continue
+		}
+		resolved, err := n.resolve(party.ID)
+		if err != nil {
+			return err
+		}
+		endpoints = append(endpoints, resolved...)
+	}
+
+	results := make([]error, len(endpoints))
+	wg := sync.WaitGroup{}
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			results[i] = n.send(context.Background(), url, request)
+		}(i, endpoint.URL)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range results {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("vendor %s of party %s: %v", endpoints[i].VendorID, endpoints[i].PartyID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not inform every party of the withdrawal: %s", strings.Join(failed, "; "))
+	}
+	log.Printf("[RemoteNegotiator] withdrawal of consent %s sent to %d endpoints\n", consentID, len(endpoints))
+	return nil
+}
+
+// resolve returns the consent endpoints of the party, one per vendor
+func (n *Negotiator) resolve(partyID string) ([]vendorEndpoint, error) {
+	entry, err := n.Registry.FindParty(partyID)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve party %s: %w", partyID, err)
+	}
+	var endpoints []vendorEndpoint
+	seen := map[string]bool{}
+	for _, endpoint := range entry.Endpoints {
+		if endpoint.Type != EndpointType {
+			continue
+		}
+		vendorID := endpoint.VendorID
+		if vendorID == "" {
+			// an endpoint without vendor is operated by the party itself
+			vendorID = partyID
+		}
+		if seen[vendorID] {
+			continue
+		}
+		seen[vendorID] = true
+		endpoints = append(endpoints, vendorEndpoint{PartyID: partyID, VendorID: vendorID, URL: endpoint.URL})
+	}
+	return endpoints, nil
+}
+
+func (n *Negotiator) HandlerType() eh.EventHandlerType {
+	return HandlerType
+}
+
+// HandleEvent schedules the distribution of the contract of a negotiation when the consent has started syncing.
+// The distribution is a deadline which passes immediately, the deadline.Scheduler dispatches it to HandleCommand
+// outside the event handler.
+func (n *Negotiator) HandleEvent(ctx context.Context, event eh.Event) error {
+	if event.EventType() != events.SyncStarted {
+		return nil
+	}
+	data, ok := event.Data().(events.SyncStartedData)
+	if !ok {
+		return errors.New("event data of wrong type")
+	}
+	distribute := &DistributeContract{ID: data.SyncID, Attempt: 1}
+	err := n.CommandHandler.HandleCommand(ctx, &deadline.ScheduleDeadline{
+		ID:       distributionDeadline(distribute.ID, distribute.Attempt),
+		Deadline: event.Timestamp(),
+		Command:  distribute,
+	})
+	if errors.Is(err, domain.ErrInvalidTransition) {
+		// scheduled when the event was handled before
+		return nil
+	}
+	return err
+}
+
+// distributionDeadline returns the ID of the deadline of an attempt to distribute the contract of a negotiation
+func distributionDeadline(syncID uuid.UUID, attempt int) uuid.UUID {
+	return deadline.ID(syncID, fmt.Sprintf("distribution/%d", attempt))
+}
+
+var _ = eh.CommandHandler(&Negotiator{})
+
+// HandleCommand implements the HandleCommand method of the eventhorizon.CommandHandler interface.
+// It posts the contract to the endpoints of the vendors which have not responded yet in parallel and records the
+// responses until the first rejection. An endpoint responding with a client error rejected the contract. When an
+// endpoint is unavailable, the next attempt is scheduled as deadline. When the attempts are used up, the
+// unavailable vendor counts as rejected. The negotiation is completed once every vendor signed.
+func (n *Negotiator) HandleCommand(ctx context.Context, command eh.Command) error {
+	cmd, ok := command.(*DistributeContract)
+	if !ok {
+		return domain.ErrUnknownCommand
+	}
+	agg, err := n.Negotiations.Load(ctx, negotiation.ConsentNegotiationAggregateType, cmd.ID)
+	if err != nil {
+		return fmt.Errorf("could not load negotiation %s: %w", cmd.ID, err)
+	}
+	started, ok := agg.(*negotiation.NegotiationAggregate)
+	if !ok || started.State != negotiation.NegotiationPending {
+		log.Printf("[RemoteNegotiator] no pending negotiation %s\n", cmd.ID)
+		return nil
+	}
+
+	request := ContractRequest{Type: ContractMessage, SyncID: cmd.ID, ConsentID: started.ConsentID, Contract: started.Contents}
+	var endpoints []vendorEndpoint
+	for _, party := range started.Parties {
+		request.Parties = append(request.Parties, party.ID)
+		for _, vendorID := range party.Vendor {
+			if responded(party, vendorID) {
+				continue
+			}
+			endpoints = append(endpoints, vendorEndpoint{PartyID: party.ID, VendorID: vendorID, URL: party.Endpoints[vendorID]})
+		}
+	}
+
+	results := make([]error, len(endpoints))
+	wg := sync.WaitGroup{}
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			results[i] = n.send(ctx, url, request)
+		}(i, endpoint.URL)
+	}
+	wg.Wait()
+
+	// The responses are recorded one by one since they all change the same aggregate
+	var unavailable []int
+	for i, endpoint := range endpoints {
+		if results[i] != nil && !rejected(results[i]) {
+			unavailable = append(unavailable, i)
+			continue
+		}
+		signed, err := n.record(ctx, cmd.ID, endpoint, results[i])
+		if err != nil || !signed {
+			return err
+		}
+	}
+
+	if len(unavailable) > 0 {
+		return n.retry(ctx, cmd, endpoints[unavailable[0]], results[unavailable[0]])
+	}
+	if err := n.CommandHandler.HandleCommand(ctx, &negotiation.CompleteNegotiation{ID: cmd.ID}); err != nil {
+		return fmt.Errorf("could not complete negotiation %s: %w", cmd.ID, err)
+	}
+	return nil
+}
+
+// retry schedules the next attempt to distribute the contract, the unavailable endpoint counts as rejected when the
+// attempts are used up
+func (n *Negotiator) retry(ctx context.Context, cmd *DistributeContract, endpoint vendorEndpoint, cause error) error {
+	retry := n.Retry
+	if retry.Attempts < 1 {
+		retry = DefaultRetry
+	}
+	next := *cmd
+	next.Attempt++
+	scheduled, err := retry.Schedule(ctx, n.CommandHandler, distributionDeadline(next.ID, next.Attempt), cmd.Attempt, &next)
+	if err != nil {
+		return err
+	}
+	if !scheduled {
+		_, err := n.record(ctx, cmd.ID, endpoint, cause)
+		return err
+	}
+	log.Printf("[RemoteNegotiator] attempt %d of %d to reach %s failed: %v\n", cmd.Attempt, retry.Attempts, endpoint.URL, cause)
+	return nil
+}
+
+// record records the response of the vendor, which signed the contract when result is nil.
+// It returns whether the vendor signed.
+func (n *Negotiator) record(ctx context.Context, syncID uuid.UUID, endpoint vendorEndpoint, result error) (bool, error) {
+	cmd := &negotiation.RecordVendorResponse{ID: syncID, PartyID: endpoint.PartyID, VendorID: endpoint.VendorID, Signed: true}
+	if result != nil {
+		cmd.Signed = false
+		cmd.Reason = result.Error()
+	}
+	if err := n.CommandHandler.HandleCommand(ctx, cmd); err != nil {
+		return false, fmt.Errorf("could not record the response of vendor %s: %w", endpoint.VendorID, err)
+	}
+	return cmd.Signed, nil
+}
+
+// responded returns true when the vendor already responded to the contract on behalf of the party
+func responded(party negotiation.Party, vendorID string) bool {
+	for _, response := range party.VendorResponses {
+		if response.VendorID == vendorID {
+			return true
+		}
+	}
+	return false
+}
+
+// rejected returns true when the endpoint responded to the request with a client error
+func rejected(err error) bool {
+	var status statusError
+	return errors.As(err, &status) && status.Code < http.StatusInternalServerError
+}
+
+// statusError is returned when an endpoint responds with a status other than 2xx
+type statusError struct {
+	URL  string
+	Code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("endpoint %s responded with status %d", e.URL, e.Code)
+}
+
+func (n *Negotiator) send(ctx context.Context, url string, request interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	response, err := n.Client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return statusError{URL: url, Code: response.StatusCode}
+	}
+	return nil
+}
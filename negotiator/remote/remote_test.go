@@ -0,0 +1,346 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	events2 "github.com/looplab/eventhorizon/aggregatestore/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/deadline"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"github.com/nuts-foundation/nuts-consent-service/domain/negotiation"
+	"github.com/nuts-foundation/nuts-consent-service/registry"
+	"github.com/nuts-foundation/nuts-consent-service/registry/memory"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// commandRecorder records the commands, it is safe for concurrent use.
+// It also acts as aggregate store of the negotiations it started.
+type commandRecorder struct {
+	commands     []eh.Command
+	negotiations map[uuid.UUID]*negotiation.NegotiationAggregate
+	mu           sync.Mutex
+}
+
+func (r *commandRecorder) HandleCommand(ctx context.Context, cmd eh.Command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, cmd)
+	if start, ok := cmd.(*negotiation.StartNegotiation); ok {
+		agg := &negotiation.NegotiationAggregate{AggregateBase: events2.NewAggregateBase(negotiation.ConsentNegotiationAggregateType, start.ID)}
+		if err := agg.HandleCommand(ctx, start); err != nil {
+			return err
+		}
+		for _, event := range agg.Events() {
+			if err := agg.ApplyEvent(ctx, event); err != nil {
+				return err
+			}
+		}
+		if r.negotiations == nil {
+			r.negotiations = map[uuid.UUID]*negotiation.NegotiationAggregate{}
+		}
+		r.negotiations[start.ID] = agg
+	}
+	return nil
+}
+
+func (r *commandRecorder) Load(ctx context.Context, aggregateType eh.AggregateType, id uuid.UUID) (eh.Aggregate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if agg, ok := r.negotiations[id]; ok {
+		return agg, nil
+	}
+	return eh.CreateAggregate(aggregateType, id)
+}
+
+func (r *commandRecorder) Save(ctx context.Context, agg eh.Aggregate) error {
+	return nil
+}
+
+func TestNegotiator(t *testing.T) {
+	var received []ContractRequest
+	mu := sync.Mutex{}
+	accepting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ContractRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, request)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer accepting.Close()
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer rejecting.Close()
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	deadline.TimeNow = func() time.Time {
+		return now
+	}
+	defer func() {
+		deadline.TimeNow = time.Now
+	}()
+
+	endpoint := func(url, vendorID string) registry.Endpoint {
+		return registry.Endpoint{Type: EndpointType, URL: url, VendorID: vendorID}
+	}
+	parties := []negotiation.Party{
+		{ID: "bsn:999999990", Role: negotiation.SubjectRole},
+		{ID: "agb:123", Role: negotiation.CustodianRole},
+		{ID: "agb:456", Role: negotiation.ActorRole},
+	}
+
+	cases := map[string]struct {
+		parties          []registry.Party
+		attempt          int
+		expectedParties  []negotiation.Party
+		expectedCommands []eh.Command
+	}{
+		"all vendors accept": {
+			[]registry.Party{
+				{ID: "agb:123", Endpoints: []registry.Endpoint{endpoint(accepting.URL, "vendor:1"), endpoint(accepting.URL, "vendor:2")}},
+				{ID: "agb:456", Endpoints: []registry.Endpoint{endpoint(accepting.URL, "")}},
+			},
+			1,
+			[]negotiation.Party{
+				{ID: "agb:123", Role: negotiation.CustodianRole, Vendor: []string{"vendor:1", "vendor:2"}, Endpoints: map[string]string{"vendor:1": accepting.URL, "vendor:2": accepting.URL}},
+				{ID: "agb:456", Role: negotiation.ActorRole, Vendor: []string{"agb:456"}, Endpoints: map[string]string{"agb:456": accepting.URL}},
+			},
+			[]eh.Command{
+				&negotiation.RecordVendorResponse{PartyID: "agb:123", VendorID: "vendor:1", Signed: true},
+				&negotiation.RecordVendorResponse{PartyID: "agb:123", VendorID: "vendor:2", Signed: true},
+				&negotiation.RecordVendorResponse{PartyID: "agb:456", VendorID: "agb:456", Signed: true},
+				&negotiation.CompleteNegotiation{},
+			},
+		},
+		"a vendor rejects": {
+			[]registry.Party{
+				{ID: "agb:123", Endpoints: []registry.Endpoint{endpoint(rejecting.URL, "vendor:1")}},
+				{ID: "agb:456", Endpoints: []registry.Endpoint{endpoint(accepting.URL, "vendor:2")}},
+			},
+			1,
+			[]negotiation.Party{
+				{ID: "agb:123", Role: negotiation.CustodianRole, Vendor: []string{"vendor:1"}, Endpoints: map[string]string{"vendor:1": rejecting.URL}},
+				{ID: "agb:456", Role: negotiation.ActorRole, Vendor: []string{"vendor:2"}, Endpoints: map[string]string{"vendor:2": accepting.URL}},
+			},
+			[]eh.Command{
+				&negotiation.RecordVendorResponse{PartyID: "agb:123", VendorID: "vendor:1", Reason: "endpoint " + rejecting.URL + " responded with status 409"},
+			},
+		},
+		"a vendor is unavailable": {
+			[]registry.Party{
+				{ID: "agb:123", Endpoints: []registry.Endpoint{endpoint(unavailable.URL, "vendor:1")}},
+				{ID: "agb:456", Endpoints: []registry.Endpoint{endpoint(accepting.URL, "vendor:2")}},
+			},
+			1,
+			[]negotiation.Party{
+				{ID: "agb:123", Role: negotiation.CustodianRole, Vendor: []string{"vendor:1"}, Endpoints: map[string]string{"vendor:1": unavailable.URL}},
+				{ID: "agb:456", Role: negotiation.ActorRole, Vendor: []string{"vendor:2"}, Endpoints: map[string]string{"vendor:2": accepting.URL}},
+			},
+			[]eh.Command{
+				&negotiation.RecordVendorResponse{PartyID: "agb:456", VendorID: "vendor:2", Signed: true},
+				&deadline.ScheduleDeadline{Deadline: now.Add(time.Second), Command: &DistributeContract{Attempt: 2}},
+			},
+		},
+		"a vendor is unavailable after the last attempt": {
+			[]registry.Party{
+				{ID: "agb:123", Endpoints: []registry.Endpoint{endpoint(unavailable.URL, "vendor:1")}},
+				{ID: "agb:456", Endpoints: []registry.Endpoint{endpoint(accepting.URL, "vendor:2")}},
+			},
+			DefaultRetry.Attempts,
+			[]negotiation.Party{
+				{ID: "agb:123", Role: negotiation.CustodianRole, Vendor: []string{"vendor:1"}, Endpoints: map[string]string{"vendor:1": unavailable.URL}},
+				{ID: "agb:456", Role: negotiation.ActorRole, Vendor: []string{"vendor:2"}, Endpoints: map[string]string{"vendor:2": accepting.URL}},
+			},
+			[]eh.Command{
+				&negotiation.RecordVendorResponse{PartyID: "agb:456", VendorID: "vendor:2", Signed: true},
+				&negotiation.RecordVendorResponse{PartyID: "agb:123", VendorID: "vendor:1", Reason: "endpoint " + unavailable.URL + " responded with status 503"},
+			},
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			recorder := &commandRecorder{}
+			negotiator := NewNegotiator(memory.NewRegistry(testcase.parties...), recorder, recorder)
+			consentID, syncID := uuid.New(), uuid.New()
+
+			if err := negotiator.Start(syncID, consentID, parties, "contract"); err != nil {
+				t.Fatal(err)
+			}
+			event := eh.NewEventForAggregate(events.SyncStarted, events.SyncStartedData{SyncID: syncID}, now, consent.ConsentAggregateType, consentID, 4)
+			if err := negotiator.HandleEvent(context.Background(), event); err != nil {
+				t.Fatal(err)
+			}
+			if err := negotiator.HandleCommand(context.Background(), &DistributeContract{ID: syncID, Attempt: testcase.attempt}); err != nil {
+				t.Fatal(err)
+			}
+
+			expected := []eh.Command{
+				&negotiation.StartNegotiation{ID: syncID, ConsentID: consentID, Contents: "contract", Parties: testcase.expectedParties},
+				&deadline.ScheduleDeadline{ID: distributionDeadline(syncID, 1), Deadline: now, Command: &DistributeContract{ID: syncID, Attempt: 1}},
+			}
+			for _, cmd := range testcase.expectedCommands {
+				switch cmd := cmd.(type) {
+				case *negotiation.RecordVendorResponse:
+					cmd.ID = syncID
+				case *negotiation.CompleteNegotiation:
+					cmd.ID = syncID
+				case *deadline.ScheduleDeadline:
+					distribute := cmd.Command.(*DistributeContract)
+					distribute.ID = syncID
+					cmd.ID = distributionDeadline(syncID, distribute.Attempt)
+				}
+				expected = append(expected, cmd)
+			}
+			if !reflect.DeepEqual(recorder.commands, expected) {
+				t.Errorf("incorrect commands")
+				t.Logf("exp: %#v", expected)
+				t.Logf("got: %#v", recorder.commands)
+			}
+		})
+	}
+
+	if len(received) == 0 || received[0].Contract != "contract" || !reflect.DeepEqual(received[0].Parties, []string{"agb:123", "agb:456"}) {
+		t.Errorf("incorrect contract request: %#v", received)
+	}
+}
+
+func TestNegotiator_Start(t *testing.T) {
+	parties := []negotiation.Party{
+		{ID: "bsn:999999990", Role: negotiation.SubjectRole},
+		{ID: "agb:123", Role: negotiation.CustodianRole},
+		{ID: "agb:456", Role: negotiation.ActorRole},
+	}
+
+	cases := map[string]struct {
+		parties       []registry.Party
+		expectedError error
+	}{
+		"party not in registry": {
+			[]registry.Party{{ID: "agb:123", Endpoints: []registry.Endpoint{{Type: EndpointType, URL: "http://localhost"}}}},
+			registry.ErrPartyNotFound,
+		},
+		"no consent endpoints": {
+			[]registry.Party{
+				{ID: "agb:123", Endpoints: []registry.Endpoint{{Type: EndpointType, URL: "http://localhost"}}},
+				{ID: "agb:456", Endpoints: []registry.Endpoint{{Type: "other", URL: "http://localhost"}}},
+			},
+			negotiation.ErrMissingParty,
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			recorder := &commandRecorder{}
+			negotiator := NewNegotiator(memory.NewRegistry(testcase.parties...), recorder, recorder)
+
+			err := negotiator.Start(uuid.New(), uuid.New(), parties, "contract")
+			if !errors.Is(err, testcase.expectedError) {
+				t.Errorf("incorrect error result")
+				t.Log("exp error: ", testcase.expectedError)
+				t.Log("got error: ", err)
+			}
+		})
+	}
+
+	t.Run("started by a previous attempt", func(t *testing.T) {
+		recorder := &commandRecorder{}
+		negotiator := NewNegotiator(memory.NewRegistry(
+			registry.Party{ID: "agb:123", Endpoints: []registry.Endpoint{{Type: EndpointType, URL: "http://localhost"}}},
+			registry.Party{ID: "agb:456", Endpoints: []registry.Endpoint{{Type: EndpointType, URL: "http://localhost"}}},
+		), recorder, &commandRecorder{})
+		consentID, syncID := uuid.New(), uuid.New()
+		if err := negotiator.Start(syncID, consentID, parties, "contract"); err != nil {
+			t.Fatal(err)
+		}
+		agg := recorder.negotiations[syncID]
+		negotiator.CommandHandler = eh.CommandHandlerFunc(func(ctx context.Context, cmd eh.Command) error {
+			return agg.HandleCommand(ctx, cmd)
+		})
+		if err := negotiator.Start(syncID, consentID, parties, "contract"); err != nil {
+			t.Errorf("expected starting the same negotiation again to succeed, got: %v", err)
+		}
+	})
+}
+
+func TestNegotiator_HandleCommand(t *testing.T) {
+	recorder := &commandRecorder{}
+	negotiator := NewNegotiator(memory.NewRegistry(), recorder, recorder)
+	if err := negotiator.HandleCommand(context.Background(), &DistributeContract{ID: uuid.New(), Attempt: 1}); err != nil {
+		t.Errorf("expected an unknown negotiation to be ignored, got: %v", err)
+	}
+	if len(recorder.commands) != 0 {
+		t.Errorf("expected no commands, got %#v", recorder.commands)
+	}
+}
+
+func TestNegotiator_Withdraw(t *testing.T) {
+	var received []WithdrawalRequest
+	mu := sync.Mutex{}
+	accepting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request WithdrawalRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, request)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer accepting.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	parties := []negotiation.Party{
+		{ID: "bsn:999999990", Role: negotiation.SubjectRole},
+		{ID: "agb:123", Role: negotiation.CustodianRole},
+		{ID: "agb:456", Role: negotiation.ActorRole},
+	}
+	consentID, syncID := uuid.New(), uuid.New()
+
+	t.Run("all endpoints informed", func(t *testing.T) {
+		negotiator := NewNegotiator(memory.NewRegistry(
+			registry.Party{ID: "agb:123", Endpoints: []registry.Endpoint{{Type: EndpointType, URL: accepting.URL, VendorID: "vendor:1"}}},
+			registry.Party{ID: "agb:456", Endpoints: []registry.Endpoint{{Type: EndpointType, URL: accepting.URL}}},
+		), &commandRecorder{}, &commandRecorder{})
+
+		if err := negotiator.Withdraw(consentID, syncID, parties, "bsn:999999990", "changed my mind"); err != nil {
+			t.Fatal(err)
+		}
+		expected := WithdrawalRequest{Type: WithdrawalMessage, SyncID: syncID, ConsentID: consentID, WithdrawnBy: "bsn:999999990", Reason: "changed my mind"}
+		if len(received) != 2 || !reflect.DeepEqual(received[0], expected) || !reflect.DeepEqual(received[1], expected) {
+			t.Errorf("incorrect withdrawal requests")
+			t.Logf("exp: 2 x %#v", expected)
+			t.Logf("got: %#v", received)
+		}
+	})
+
+	t.Run("an endpoint fails", func(t *testing.T) {
+		negotiator := NewNegotiator(memory.NewRegistry(
+			registry.Party{ID: "agb:123", Endpoints: []registry.Endpoint{{Type: EndpointType, URL: failing.URL, VendorID: "vendor:1"}}},
+		), &commandRecorder{}, &commandRecorder{})
+
+		if err := negotiator.Withdraw(consentID, syncID, parties, "bsn:999999990", ""); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
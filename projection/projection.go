@@ -0,0 +1,186 @@
+package projection
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventhandler/projector"
+	"log"
+	"sync"
+)
+
+// LoadFunc loads all stored events in the order in which they were stored
+type LoadFunc func(ctx context.Context) ([]eh.Event, error)
+
+// ProgressFunc is called while rebuilding with the number of events handled so far and the total number of events
+type ProgressFunc func(handled, total int)
+
+// Projection runs a projector on a repo which can be rebuilt from the stored events.
+// A rebuild projects all events into a new repo, which then replaces the current repo atomically.
+// Readers should use Repo, which always reads from the current repo.
+type Projection struct {
+	projector     projector.Projector
+	matcher       eh.EventMatcher
+	entityFactory func() eh.Entity
+	repoFactory   func() eh.ReadWriteRepo
+
+	// mu guards the current repo and handler, a rebuild holds it while catching up and swapping
+	mu       sync.RWMutex
+	repo     eh.ReadWriteRepo
+	handler  *projector.EventHandler
+	rebuilds sync.Mutex
+}
+
+var _ = eh.EventHandler(&Projection{})
+
+// New creates a Projection projecting the events selected by matcher into repos created by repoFactory
+func New(p projector.Projector, matcher eh.EventMatcher, entityFactory func() eh.Entity, repoFactory func() eh.ReadWriteRepo) *Projection {
+	projection := &Projection{
+		projector:     p,
+		matcher:       matcher,
+		entityFactory: entityFactory,
+		repoFactory:   repoFactory,
+	}
+	projection.repo, projection.handler = projection.newHandler()
+	return projection
+}
+
+func (p *Projection) newHandler() (eh.ReadWriteRepo, *projector.EventHandler) {
+	repo := p.repoFactory()
+	handler := projector.NewEventHandler(p.projector, repo)
+	handler.SetEntityFactory(p.entityFactory)
+	return repo, handler
+}
+
+// Type returns the type of the projector
+func (p *Projection) Type() projector.Type {
+	return p.projector.ProjectorType()
+}
+
+// HandlerType implements the HandlerType method of the eventhorizon.EventHandler interface
+func (p *Projection) HandlerType() eh.EventHandlerType {
+	return eh.EventHandlerType("projection_" + p.projector.ProjectorType())
+}
+
+// HandleEvent implements the HandleEvent method of the eventhorizon.EventHandler interface.
+// Events which are already projected, e.g. by a rebuild catching up, are skipped.
+// The lock is not held while projecting, the projector may wait for an earlier version of the entity. An event
+// projected into a repo which is replaced meanwhile is not lost: it was stored before it was handled, so the
+// rebuild catches up with it.
+func (p *Projection) HandleEvent(ctx context.Context, event eh.Event) error {
+	p.mu.RLock()
+	repo, handler := p.repo, p.handler
+	p.mu.RUnlock()
+	if projected(ctx, repo, event) {
+		return nil
+	}
+	return handler.HandleEvent(ctx, event)
+}
+
+// Rebuild projects all events returned by load into a new repo and replaces the current repo with it.
+// Events handled while rebuilding still update the current repo. Once all loaded events are projected,
+// the events stored in the meantime are loaded again and projected before the repos are swapped.
+// The current repo is kept when the rebuild fails.
+func (p *Projection) Rebuild(ctx context.Context, load LoadFunc, progress ProgressFunc) error {
+	p.rebuilds.Lock()
+	defer p.rebuilds.Unlock()
+
+	repo, handler := p.newHandler()
+	history, err := load(ctx)
+	if err != nil {
+		return fmt.Errorf("could not load events: %w", err)
+	}
+	if err := p.replay(ctx, handler, history, 0, progress); err != nil {
+		return err
+	}
+
+	// block new events while catching up, so no event is missed between loading and swapping
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	latest, err := load(ctx)
+	if err != nil {
+		return fmt.Errorf("could not load events: %w", err)
+	}
+	if err := p.replay(ctx, handler, latest, len(history), progress); err != nil {
+		return err
+	}
+	p.repo, p.handler = repo, handler
+	return nil
+}
+
+// replay projects the events starting at offset
+func (p *Projection) replay(ctx context.Context, handler *projector.EventHandler, events []eh.Event, offset int, progress ProgressFunc) error {
+	for i := offset; i < len(events); i++ {
+		event := events[i]
+		if p.matcher(event) {
+			if err := handler.HandleEvent(ctx, event); err != nil {
+				return fmt.Errorf("could not project event %d (%s): %w", i, event, err)
+			}
+		}
+		if progress != nil {
+			progress(i+1, len(events))
+		}
+	}
+	return nil
+}
+
+// projected returns true when the entity of the event is already at the version of the event
+func projected(ctx context.Context, repo eh.ReadRepo, event eh.Event) bool {
+	entity, err := repo.Find(ctx, event.AggregateID())
+	if err != nil {
+		return false
+	}
+	versionable, ok := entity.(eh.Versionable)
+	return ok && versionable.AggregateVersion() >= event.Version()
+}
+
+// Repo returns a repo which reads from, and writes to, the current repo of the projection
+func (p *Projection) Repo() eh.ReadWriteRepo {
+	return currentRepo{p}
+}
+
+func (p *Projection) current() eh.ReadWriteRepo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.repo
+}
+
+// currentRepo delegates to the current repo of a projection
+type currentRepo struct {
+	projection *Projection
+}
+
+// Parent implements the Parent method of the eventhorizon.ReadRepo interface
+func (r currentRepo) Parent() eh.ReadRepo {
+	return r.projection.current()
+}
+
+// Find implements the Find method of the eventhorizon.ReadRepo interface
+func (r currentRepo) Find(ctx context.Context, id uuid.UUID) (eh.Entity, error) {
+	return r.projection.current().Find(ctx, id)
+}
+
+// FindAll implements the FindAll method of the eventhorizon.ReadRepo interface
+func (r currentRepo) FindAll(ctx context.Context) ([]eh.Entity, error) {
+	return r.projection.current().FindAll(ctx)
+}
+
+// Save implements the Save method of the eventhorizon.WriteRepo interface
+func (r currentRepo) Save(ctx context.Context, entity eh.Entity) error {
+	return r.projection.current().Save(ctx, entity)
+}
+
+// Remove implements the Remove method of the eventhorizon.WriteRepo interface
+func (r currentRepo) Remove(ctx context.Context, id uuid.UUID) error {
+	return r.projection.current().Remove(ctx, id)
+}
+
+// LogProgress returns a ProgressFunc logging the progress of rebuilding the projection every 1000 events
+func LogProgress(projectorType projector.Type) ProgressFunc {
+	return func(handled, total int) {
+		if handled%1000 == 0 || handled == total {
+			log.Printf("[Projection] %s: replayed %d of %d events\n", projectorType, handled, total)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package projection
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/looplab/eventhorizon/repo/version"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"github.com/nuts-foundation/nuts-consent-service/domain/events"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newProjection() *Projection {
+	return New(&consent.ConsentProjector{}, eh.MatchAggregate(consent.ConsentAggregateType),
+		func() eh.Entity { return &consent.Consent{} },
+		func() eh.ReadWriteRepo { return version.NewRepo(memory.NewRepo()) })
+}
+
+func consentEvents(id uuid.UUID) []eh.Event {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return []eh.Event{
+		eh.NewEventForAggregate(events.Proposed, events.ProposedData{ID: id, CustodianID: "agb:123", SubjectID: "bsn:999999990", ActorID: "agb:456", Start: now}, now, consent.ConsentAggregateType, id, 1),
+		eh.NewEventForAggregate(events.Unique, nil, now, consent.ConsentAggregateType, id, 2),
+		eh.NewEventForAggregate(events.CustodianChecked, nil, now, consent.ConsentAggregateType, id, 3),
+	}
+}
+
+func TestProjection_Rebuild(t *testing.T) {
+	id := uuid.New()
+	history := append(consentEvents(id), eh.NewEventForAggregate(events.NegotiationStarted, nil, time.Now(), eh.AggregateType("ConsentNegotiation"), uuid.New(), 1))
+	p := newProjection()
+	repo := p.Repo()
+
+	// a stale read model, as if it had been projected by an older projector
+	if err := repo.Save(context.Background(), &consent.Consent{ID: id, Status: consent.ConsentRequestPending, Version: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress [][2]int
+	err := p.Rebuild(context.Background(), func(ctx context.Context) ([]eh.Event, error) {
+		return history, nil
+	}, func(handled, total int) {
+		progress = append(progress, [2]int{handled, total})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity, err := repo.Find(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model := entity.(*consent.Consent); model.Status != consent.ConsentRequestChecked || model.Version != 3 {
+		t.Errorf("expected the rebuilt read model, got %+v", model)
+	}
+	if expected := [][2]int{{1, 4}, {2, 4}, {3, 4}, {4, 4}}; !reflect.DeepEqual(progress, expected) {
+		t.Errorf("incorrect progress")
+		t.Logf("exp: %v", expected)
+		t.Logf("got: %v", progress)
+	}
+
+	// events which are already projected are skipped
+	if err := p.HandleEvent(context.Background(), history[2]); err != nil {
+		t.Errorf("expected a projected event to be skipped, got %v", err)
+	}
+}
+
+func TestProjection_Rebuild_CatchesUp(t *testing.T) {
+	id := uuid.New()
+	history := consentEvents(id)
+	p := newProjection()
+
+	// the last event is stored while the first events are replayed
+	loads := 0
+	err := p.Rebuild(context.Background(), func(ctx context.Context) ([]eh.Event, error) {
+		loads++
+		if loads == 1 {
+			return history[:2], nil
+		}
+		return history, nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity, err := p.Repo().Find(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model := entity.(*consent.Consent); model.Version != 3 {
+		t.Errorf("expected the event stored while rebuilding to be projected, got %+v", model)
+	}
+}
+
+func TestProjection_Rebuild_Failure(t *testing.T) {
+	id := uuid.New()
+	p := newProjection()
+	for _, event := range consentEvents(id) {
+		if err := p.HandleEvent(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]LoadFunc{
+		"load fails": func(ctx context.Context) ([]eh.Event, error) {
+			return nil, errors.New("disk on fire")
+		},
+		"projection fails": func(ctx context.Context) ([]eh.Event, error) {
+			return []eh.Event{eh.NewEventForAggregate(eh.EventType("consent:unknown"), nil, time.Now(), consent.ConsentAggregateType, id, 1)}, nil
+		},
+	}
+
+	for name, load := range cases {
+		t.Run(name, func(t *testing.T) {
+			if err := p.Rebuild(context.Background(), load, nil); err == nil {
+				t.Error("expected an error")
+			}
+			entity, err := p.Repo().Find(context.Background(), id)
+			if err != nil || entity.(*consent.Consent).Version != 3 {
+				t.Errorf("expected the current repo to be kept, got %+v, %v", entity, err)
+			}
+		})
+	}
+}
+
+func TestProjection_Rebuild_WhileWaiting(t *testing.T) {
+	id := uuid.New()
+	history := consentEvents(id)
+	p := newProjection()
+
+	// the projector waits for the earlier versions of the entity, which are never handled
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := make(chan error)
+	go func() {
+		waiting <- p.HandleEvent(ctx, history[2])
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	rebuilt := make(chan error)
+	go func() {
+		rebuilt <- p.Rebuild(context.Background(), func(ctx context.Context) ([]eh.Event, error) {
+			return history, nil
+		}, nil)
+	}()
+	select {
+	case err := <-rebuilt:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the rebuild not to wait for the event handler")
+	}
+	cancel()
+	<-waiting
+}
@@ -0,0 +1,264 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"sort"
+	"sync"
+	"time"
+)
+
+// indexKeys are the index entries of a single consent
+type indexKeys struct {
+	subject   string
+	custodian string
+	actor     string
+	status    consent.ConsentAggregateState
+}
+
+type idSet map[uuid.UUID]struct{}
+
+// Repo is a repository of consent.Consent read models which keeps indexes on the parties and status.
+// The indexes are updated on every Save, so they are always consistent with the stored read models.
+type Repo struct {
+	eh.ReadWriteRepo
+
+	bySubject   map[string]idSet
+	byCustodian map[string]idSet
+	byActor     map[string]idSet
+	byStatus    map[consent.ConsentAggregateState]idSet
+	keys        map[uuid.UUID]indexKeys
+	mu          sync.RWMutex
+}
+
+var _ = eh.ReadWriteRepo(&Repo{})
+
+// NewRepo creates a Repo storing the read models in repo
+func NewRepo(repo eh.ReadWriteRepo) *Repo {
+	return &Repo{
+		ReadWriteRepo: repo,
+		bySubject:     map[string]idSet{},
+		byCustodian:   map[string]idSet{},
+		byActor:       map[string]idSet{},
+		byStatus:      map[consent.ConsentAggregateState]idSet{},
+		keys:          map[uuid.UUID]indexKeys{},
+	}
+}
+
+// Parent implements the Parent method of the eventhorizon.ReadRepo interface
+func (r *Repo) Parent() eh.ReadRepo {
+	return r.ReadWriteRepo
+}
+
+// Repository returns the Repo in the chain of parents of repo, or nil when there is none
+func Repository(repo eh.ReadRepo) *Repo {
+	if repo == nil {
+		return nil
+	}
+	if r, ok := repo.(*Repo); ok {
+		return r
+	}
+	return Repository(repo.Parent())
+}
+
+// Save implements the Save method of the eventhorizon.WriteRepo interface
+func (r *Repo) Save(ctx context.Context, entity eh.Entity) error {
+	model, ok := entity.(*consent.Consent)
+	if !ok {
+		return errors.New("model is of incorrect type")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.ReadWriteRepo.Save(ctx, entity); err != nil {
+		return err
+	}
+	r.unindex(model.ID)
+	keys := indexKeys{
+		subject:   domain.NormaliseIdentifier(model.SubjectID),
+		custodian: domain.NormaliseIdentifier(model.CustodianID),
+		actor:     domain.NormaliseIdentifier(model.ActorID),
+		status:    model.Status,
+	}
+	add(r.bySubject, keys.subject, model.ID)
+	add(r.byCustodian, keys.custodian, model.ID)
+	add(r.byActor, keys.actor, model.ID)
+	if r.byStatus[keys.status] == nil {
+		r.byStatus[keys.status] = idSet{}
+	}
+	r.byStatus[keys.status][model.ID] = struct{}{}
+	r.keys[model.ID] = keys
+	return nil
+}
+
+// Remove implements the Remove method of the eventhorizon.WriteRepo interface
+func (r *Repo) Remove(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.ReadWriteRepo.Remove(ctx, id); err != nil {
+		return err
+	}
+	r.unindex(id)
+	return nil
+}
+
+func (r *Repo) unindex(id uuid.UUID) {
+	keys, ok := r.keys[id]
+	if !ok {
+		return
+	}
+	delete(r.bySubject[keys.subject], id)
+	delete(r.byCustodian[keys.custodian], id)
+	delete(r.byActor[keys.actor], id)
+	delete(r.byStatus[keys.status], id)
+	delete(r.keys, id)
+}
+
+func add(index map[string]idSet, key string, id uuid.UUID) {
+	if index[key] == nil {
+		index[key] = idSet{}
+	}
+	index[key][id] = struct{}{}
+}
+
+// candidates returns the IDs matching the indexed fields of the filter, or all IDs when none is set
+func (r *Repo) candidates(filter Filter) []uuid.UUID {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var sets []idSet
+	if filter.SubjectID != "" {
+		sets = append(sets, r.bySubject[domain.NormaliseIdentifier(filter.SubjectID)])
+	}
+	if filter.CustodianID != "" {
+		sets = append(sets, r.byCustodian[domain.NormaliseIdentifier(filter.CustodianID)])
+	}
+	if filter.ActorID != "" {
+		sets = append(sets, r.byActor[domain.NormaliseIdentifier(filter.ActorID)])
+	}
+	if filter.Status != "" {
+		sets = append(sets, r.byStatus[filter.Status])
+	}
+
+	var ids []uuid.UUID
+	if len(sets) == 0 {
+		for id := range r.keys {
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	// intersect, starting with the smallest set
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	for id := range sets[0] {
+		inAll := true
+		for _, set := range sets[1:] {
+			if _, ok := set[id]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Filter selects consents, fields which are not set match every consent
+type Filter struct {
+	SubjectID   string
+	CustodianID string
+	ActorID     string
+	Status      consent.ConsentAggregateState
+	// ValidAt selects the consents of which the validity period contains the time
+	ValidAt time.Time
+}
+
+// ErrNotIndexed is returned when the repo of the Service is not backed by a Repo
+var ErrNotIndexed = errors.New("consent repo is not indexed")
+
+// Service answers queries on the consents
+type Service struct {
+	repo eh.ReadRepo
+}
+
+// NewService creates a Service querying the read models in repo, which must be or wrap a Repo.
+// The Repo is looked up on every query, so it may be replaced by rebuilding the projection.
+func NewService(repo eh.ReadRepo) *Service {
+	return &Service{repo: repo}
+}
+
+// Get returns the consent with the given ID
+func (s *Service) Get(ctx context.Context, id uuid.UUID) (*consent.Consent, error) {
+	entity, err := s.repo.Find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	model, ok := entity.(*consent.Consent)
+	if !ok {
+		return nil, errors.New("model is of incorrect type")
+	}
+	return model, nil
+}
+
+// Find returns the consents matching the filter, ordered by the start of their validity period
+func (s *Service) Find(ctx context.Context, filter Filter) ([]*consent.Consent, error) {
+	index := Repository(s.repo)
+	if index == nil {
+		return nil, ErrNotIndexed
+	}
+	results := []*consent.Consent{}
+	for _, id := range index.candidates(filter) {
+		model, err := s.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !filter.ValidAt.IsZero() && !ValidAt(*model, filter.ValidAt) {
+			continue
+		}
+		results = append(results, model)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].Start.Equal(results[j].Start) {
+			return results[i].Start.Before(results[j].Start)
+		}
+		return results[i].ID.String() < results[j].ID.String()
+	})
+	return results, nil
+}
+
+// ActiveConsent returns the consent for the subject and actor which is in force at the given time.
+// It returns nil when there is no such consent.
+func (s *Service) ActiveConsent(ctx context.Context, subjectID, actorID string, at time.Time) (*consent.Consent, error) {
+	results, err := s.Find(ctx, Filter{SubjectID: subjectID, ActorID: actorID})
+	if err != nil {
+		return nil, err
+	}
+	for _, model := range results {
+		if InForce(*model, at) {
+			return model, nil
+		}
+	}
+	return nil, nil
+}
+
+// InForce returns true when the consent is completed and valid at the time, or when it is being amended
+// and the previous version is valid at the time
+func InForce(model consent.Consent, at time.Time) bool {
+	if model.Previous != nil {
+		return validBetween(model.Previous.Start, model.Previous.End, at)
+	}
+	return model.Status == consent.ConsentRequestCompleted && ValidAt(model, at)
+}
+
+// ValidAt returns true when the validity period of the consent contains the time, a zero End never expires
+func ValidAt(model consent.Consent, at time.Time) bool {
+	return validBetween(model.Start, model.End, at)
+}
+
+func validBetween(start, end time.Time, at time.Time) bool {
+	return !at.Before(start) && (end.IsZero() || at.Before(end))
+}
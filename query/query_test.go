@@ -0,0 +1,130 @@
+package query
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/looplab/eventhorizon/repo/memory"
+	"github.com/nuts-foundation/nuts-consent-service/domain/consent"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestService_Find(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	active := &consent.Consent{ID: uuid.New(), Status: consent.ConsentRequestCompleted, CustodianID: "agb:123", SubjectID: "bsn:999999990", ActorID: "agb:456", Start: start, End: start.AddDate(1, 0, 0)}
+	openEnded := &consent.Consent{ID: uuid.New(), Status: consent.ConsentRequestCompleted, CustodianID: "agb:123", SubjectID: "bsn:999999990", ActorID: "agb:789", Start: start.AddDate(0, 1, 0)}
+	pending := &consent.Consent{ID: uuid.New(), Status: consent.ConsentRequestPending, CustodianID: "agb:321", SubjectID: "bsn:999999990", ActorID: "agb:456", Start: start.AddDate(0, 2, 0)}
+
+	repo := NewRepo(memory.NewRepo())
+	for _, model := range []*consent.Consent{active, openEnded, pending} {
+		if err := repo.Save(context.Background(), model); err != nil {
+			t.Fatal(err)
+		}
+	}
+	service := NewService(repo)
+
+	cases := map[string]struct {
+		filter   Filter
+		expected []*consent.Consent
+	}{
+		"all": {
+			Filter{},
+			[]*consent.Consent{active, openEnded, pending},
+		},
+		"by subject": {
+			Filter{SubjectID: "bsn:999999990"},
+			[]*consent.Consent{active, openEnded, pending},
+		},
+		"by custodian": {
+			Filter{CustodianID: "agb:123"},
+			[]*consent.Consent{active, openEnded},
+		},
+		"by actor, not normalised": {
+			Filter{ActorID: " AGB:456 "},
+			[]*consent.Consent{active, pending},
+		},
+		"by status": {
+			Filter{Status: consent.ConsentRequestPending},
+			[]*consent.Consent{pending},
+		},
+		"by actor and status": {
+			Filter{ActorID: "agb:456", Status: consent.ConsentRequestCompleted},
+			[]*consent.Consent{active},
+		},
+		"valid at": {
+			Filter{ValidAt: start.AddDate(1, 0, 0)},
+			[]*consent.Consent{openEnded, pending},
+		},
+		"unknown subject": {
+			Filter{SubjectID: "bsn:123456782"},
+			[]*consent.Consent{},
+		},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			results, err := service.Find(context.Background(), testcase.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results, testcase.expected) {
+				t.Errorf("incorrect results")
+				t.Logf("exp: %+v", testcase.expected)
+				t.Logf("got: %+v", results)
+			}
+		})
+	}
+}
+
+func TestService_ActiveConsent(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	model := &consent.Consent{ID: uuid.New(), Status: consent.ConsentRequestSyncing, CustodianID: "agb:123", SubjectID: "bsn:999999990", ActorID: "agb:456", Start: start, End: start.AddDate(1, 0, 0)}
+	repo := NewRepo(memory.NewRepo())
+	service := NewService(repo)
+	if err := repo.Save(context.Background(), model); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := service.ActiveConsent(context.Background(), "bsn:999999990", "agb:456", start); err != nil || found != nil {
+		t.Errorf("expected no active consent while syncing, got %+v, %v", found, err)
+	}
+
+	// completing the consent must move it to the other status index
+	completed := *model
+	completed.Status = consent.ConsentRequestCompleted
+	if err := repo.Save(context.Background(), &completed); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := service.ActiveConsent(context.Background(), "bsn:999999990", "agb:456", start); err != nil || found == nil || found.ID != model.ID {
+		t.Errorf("expected active consent %s, got %+v, %v", model.ID, found, err)
+	}
+	if found, _ := service.ActiveConsent(context.Background(), "bsn:999999990", "agb:456", start.AddDate(1, 0, 0)); found != nil {
+		t.Errorf("expected no active consent after the end, got %+v", found)
+	}
+	if found, _ := service.ActiveConsent(context.Background(), "bsn:999999990", "agb:456", start.Add(-time.Second)); found != nil {
+		t.Errorf("expected no active consent before the start, got %+v", found)
+	}
+
+	// the previous version stays in force while the consent is being amended
+	amending := completed
+	amending.Status = consent.ConsentRequestPending
+	amending.End = start.AddDate(2, 0, 0)
+	amending.Previous = &consent.ConsentVersion{TermsVersion: 1, Start: start, End: start.AddDate(1, 0, 0)}
+	if err := repo.Save(context.Background(), &amending); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := service.ActiveConsent(context.Background(), "bsn:999999990", "agb:456", start); err != nil || found == nil || found.ID != model.ID {
+		t.Errorf("expected active consent %s while amending, got %+v, %v", model.ID, found, err)
+	}
+	if found, _ := service.ActiveConsent(context.Background(), "bsn:999999990", "agb:456", start.AddDate(1, 6, 0)); found != nil {
+		t.Errorf("expected no active consent in the amended period before the amendment completed, got %+v", found)
+	}
+
+	if err := repo.Remove(context.Background(), model.ID); err != nil {
+		t.Fatal(err)
+	}
+	if results, _ := service.Find(context.Background(), Filter{SubjectID: "bsn:999999990"}); len(results) != 0 {
+		t.Errorf("expected removed consent to be unindexed, got %+v", results)
+	}
+}
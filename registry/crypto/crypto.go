@@ -0,0 +1,34 @@
+package crypto
+
+import (
+	"github.com/nuts-foundation/nuts-consent-service/registry"
+	"github.com/nuts-foundation/nuts-crypto/pkg/types"
+)
+
+// KeyStore tells whether a key has been generated for a legal entity, it is implemented by the nuts-crypto client
+type KeyStore interface {
+	KeyExistsFor(legalEntity types.LegalEntity) bool
+}
+
+// Registry is a PartyRegistry of the parties this node holds a key for, these are the parties it can act as
+// custodian for. The details of the parties are looked up in the wrapped registry.
+type Registry struct {
+	Keys    KeyStore
+	Parties registry.PartyRegistry
+}
+
+var _ = registry.PartyRegistry(&Registry{})
+
+// NewRegistry creates a Registry which checks the keys in the key store and looks up the parties in the given registry
+func NewRegistry(keys KeyStore, parties registry.PartyRegistry) *Registry {
+	return &Registry{Keys: keys, Parties: parties}
+}
+
+// FindParty implements the FindParty method of the registry.PartyRegistry interface.
+// A party without a key is not found, even when the wrapped registry knows it.
+func (r *Registry) FindParty(id string) (registry.Party, error) {
+	if !r.Keys.KeyExistsFor(types.LegalEntity{URI: id}) {
+		return registry.Party{}, registry.ErrPartyNotFound
+	}
+	return r.Parties.FindParty(id)
+}
@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"errors"
+	"github.com/nuts-foundation/nuts-consent-service/registry"
+	"github.com/nuts-foundation/nuts-consent-service/registry/memory"
+	"github.com/nuts-foundation/nuts-crypto/pkg/types"
+	"reflect"
+	"testing"
+)
+
+// keys is a KeyStore holding keys for the legal entities in the map
+type keys map[string]bool
+
+func (k keys) KeyExistsFor(legalEntity types.LegalEntity) bool {
+	return k[legalEntity.URI]
+}
+
+func TestRegistry_FindParty(t *testing.T) {
+	party := registry.Party{ID: "agb:123", Name: "Zorginstelling"}
+	r := NewRegistry(keys{"agb:123": true, "agb:789": true}, memory.NewRegistry(party, registry.Party{ID: "agb:456"}))
+
+	cases := map[string]struct {
+		id            string
+		expectedParty registry.Party
+		expectedError error
+	}{
+		"key and known party":  {"agb:123", party, nil},
+		"no key":               {"agb:456", registry.Party{}, registry.ErrPartyNotFound},
+		"key of unknown party": {"agb:789", registry.Party{}, registry.ErrPartyNotFound},
+	}
+
+	for name, testcase := range cases {
+		t.Run(name, func(t *testing.T) {
+			found, err := r.FindParty(testcase.id)
+			if !errors.Is(err, testcase.expectedError) {
+				t.Errorf("incorrect error result")
+				t.Log("exp error: ", testcase.expectedError)
+				t.Log("got error: ", err)
+			}
+			if !reflect.DeepEqual(found, testcase.expectedParty) {
+				t.Errorf("incorrect party")
+				t.Logf("exp: %#v", testcase.expectedParty)
+				t.Logf("got: %#v", found)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package memory
+
+import (
+	"encoding/json"
+	"github.com/nuts-foundation/nuts-consent-service/domain"
+	"github.com/nuts-foundation/nuts-consent-service/registry"
+	"io/ioutil"
+	"sync"
+)
+
+// Registry is an in-memory PartyRegistry, useful for local use and testing
+type Registry struct {
+	parties map[string]registry.Party
+	mu      sync.RWMutex
+}
+
+var _ = registry.PartyRegistry(&Registry{})
+
+// NewRegistry creates a Registry containing the given parties
+func NewRegistry(parties ...registry.Party) *Registry {
+	r := &Registry{parties: map[string]registry.Party{}}
+	for _, party := range parties {
+		r.Add(party)
+	}
+	return r
+}
+
+// LoadRegistry creates a Registry from a JSON file containing a list of parties
+func LoadRegistry(path string) (*Registry, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var parties []registry.Party
+	if err := json.Unmarshal(raw, &parties); err != nil {
+		return nil, err
+	}
+	return NewRegistry(parties...), nil
+}
+
+// Add adds or replaces a party
+func (r *Registry) Add(party registry.Party) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.parties[domain.NormaliseIdentifier(party.ID)] = party
+}
+
+// FindParty implements the FindParty method of the registry.PartyRegistry interface
+func (r *Registry) FindParty(id string) (registry.Party, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	party, ok := r.parties[domain.NormaliseIdentifier(id)]
+	if !ok {
+		return registry.Party{}, registry.ErrPartyNotFound
+	}
+	return party, nil
+}
@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"github.com/nuts-foundation/nuts-consent-service/registry"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRegistry_FindParty(t *testing.T) {
+	party := registry.Party{ID: "agb:123", Name: "Zorginstelling", VendorIDs: []string{"vendor:1"}}
+	r := NewRegistry(party)
+
+	t.Run("known party", func(t *testing.T) {
+		found, err := r.FindParty("agb:123")
+		if err != nil || !reflect.DeepEqual(found, party) {
+			t.Errorf("expected %v, got %v, %v", party, found, err)
+		}
+	})
+
+	t.Run("identifier is normalised", func(t *testing.T) {
+		if _, err := r.FindParty(" AGB:123"); err != nil {
+			t.Errorf("expected party to be found, got %v", err)
+		}
+	})
+
+	t.Run("unknown party", func(t *testing.T) {
+		if _, err := r.FindParty("agb:456"); err != registry.ErrPartyNotFound {
+			t.Errorf("expected ErrPartyNotFound, got %v", err)
+		}
+	})
+}
+
+func TestLoadRegistry(t *testing.T) {
+	file, err := ioutil.TempFile("", "registry*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`[{"id":"agb:123","name":"Zorginstelling","vendors":["vendor:1"],"endpoints":[{"type":"consent","url":"http://localhost:1323"}]}]`)
+	file.Close()
+
+	r, err := LoadRegistry(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	party, err := r.FindParty("agb:123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := registry.Party{
+		ID:        "agb:123",
+		Name:      "Zorginstelling",
+		VendorIDs: []string{"vendor:1"},
+		Endpoints: []registry.Endpoint{{Type: "consent", URL: "http://localhost:1323"}},
+	}
+	if !reflect.DeepEqual(party, expected) {
+		t.Errorf("expected %v, got %v", expected, party)
+	}
+
+	if _, err := LoadRegistry("does-not-exist.json"); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
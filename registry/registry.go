@@ -0,0 +1,28 @@
+package registry
+
+import "errors"
+
+// ErrPartyNotFound is returned when the registry does not know the party
+var ErrPartyNotFound = errors.New("party not found")
+
+// PartyRegistry resolves the parties involved in a consent
+type PartyRegistry interface {
+	// FindParty returns the party for the identifier or ErrPartyNotFound
+	FindParty(id string) (Party, error)
+}
+
+// Party is a care provider or other organisation known in the registry
+type Party struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	VendorIDs []string   `json:"vendors"`
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// Endpoint is an address at which a party can be reached
+type Endpoint struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// VendorID identifies the vendor operating the endpoint on behalf of the party
+	VendorID string `json:"vendor"`
+}
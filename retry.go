@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	eh "github.com/looplab/eventhorizon"
+	"github.com/nuts-foundation/nuts-consent-service/eventstore/bolt"
+	"log"
+)
+
+// RetryOnConflict retries a command when the aggregate was changed by another command between loading and saving it.
+// The sagas react to the same events in parallel, so their commands regularly race for the same aggregate version.
+func RetryOnConflict(attempts int) eh.CommandHandlerMiddleware {
+	return func(h eh.CommandHandler) eh.CommandHandler {
+		return eh.CommandHandlerFunc(func(ctx context.Context, command eh.Command) error {
+			var err error
+			for i := 0; i < attempts; i++ {
+				if err = h.HandleCommand(ctx, command); !isConflict(err) {
+					return err
+				}
+				log.Printf("[RetryOnConflict] conflict handling %s, attempt %d: %v\n", command.CommandType(), i+1, err)
+			}
+			return err
+		})
+	}
+}
+
+// isConflict returns true for an optimistic concurrency conflict, storage failures are not retried
+func isConflict(err error) bool {
+	esErr, ok := err.(eh.EventStoreError)
+	return ok && esErr.Err == bolt.ErrVersionConflict
+}